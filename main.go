@@ -4,30 +4,68 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/travisjeffery/writegood/server"
+	"github.com/travisjeffery/writegood/server/keys"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "keygen":
+			keygenCommand(os.Args[2:])
+			return
+		case "keys":
+			keysCommand(os.Args[2:])
+			return
+		}
+	}
+
 	var config server.Config
 
-	flag.StringVar(&config.Connect, "connect", "", "db connect string")
+	flag.StringVar(&config.Connect, "connect", "", "db connect string, postgres://... or sqlite3://path/to/writegood.db")
 	flag.StringVar(&config.Migrations, "migrations", "migrations", "migrations src")
+	flag.StringVar(&config.SQLiteMigrations, "sqlite_migrations", "file://migrations/sqlite", "migrations src used with sqlite")
 	flag.StringVar(&config.Templates, "templates", "templates", "templates src")
 	flag.StringVar(&config.SendGridAPIKey, "sendgrid_api_key", os.Getenv("SENDGRID_API_KEY"), "send grid api key")
+	flag.StringVar(&config.Mailer, "mailer", "sendgrid", "mailer used to send emails: sendgrid, smtp, file or log")
+	flag.StringVar(&config.SMTPAddr, "smtp_addr", "localhost:25", "smtp server address used by the smtp mailer")
+	flag.StringVar(&config.SMTPUsername, "smtp_username", "", "smtp username used by the smtp mailer")
+	flag.StringVar(&config.SMTPPassword, "smtp_password", os.Getenv("SMTP_PASSWORD"), "smtp password used by the smtp mailer")
+	flag.StringVar(&config.MailDir, "mail_dir", "mail", "dir the file mailer writes .eml files to")
+	flag.IntVar(&config.MailMaxAttempts, "mail_max_attempts", 8, "number of times to try sending an email before giving up")
 	flag.StringVar(&config.Domain, "domain", "http://localhost:8080", "domain")
 	flag.StringVar(&config.FromName, "from_name", "Travis Jeffery", "name used to send emails from")
 	flag.StringVar(&config.FromAccount, "from_account", "tj@writegood.app", "account used to send emails from")
 	flag.DurationVar(&config.SignInExpire, "sign_in_expire", 15*time.Minute, "sign in expire duration")
-	flag.StringVar(&config.HashSalt, "hash_salt", "", "hash salt used for sign in tokens")
+	flag.DurationVar(&config.TrashRetention, "trash_retention", 30*24*time.Hour, "how long trashed documents are kept before they're purged")
+	flag.StringVar(&config.RateLimiter, "rate_limiter", "memory", "rate limiter: memory or postgres to share limits between instances")
+	flag.IntVar(&config.SignInIPLimit, "sign_in_ip_limit", 20, "sign in emails an ip may request per hour")
+	flag.IntVar(&config.SignInEmailLimit, "sign_in_email_limit", 5, "sign in emails an email may request per hour")
+	flag.IntVar(&config.ShareLinkPasswordLimit, "share_link_password_limit", 10, "passwords an ip may try for a share link per hour")
+	flag.BoolVar(&config.TrustProxy, "trust_proxy", false, "use the X-Forwarded-For header for client ips")
+	flag.IntVar(&config.TrustedProxies, "trusted_proxies", 0, "how many proxies are in front of the one connecting to writegood, their X-Forwarded-For entries are skipped to find the client ip")
+	flag.BoolVar(&config.SignUp, "sign_up", true, "let unknown emails sign up")
+	signUpDomains := flag.String("sign_up_domains", "", "comma separated email domains allowed to sign up, any domain if empty")
+	flag.StringVar(&config.OIDCName, "oidc_name", "sso", "name of the openid connect login provider used in its urls")
+	flag.StringVar(&config.OIDCIssuer, "oidc_issuer", "", "openid connect issuer url, enables openid connect login if set")
+	flag.StringVar(&config.OIDCClientID, "oidc_client_id", "", "openid connect client id")
+	flag.StringVar(&config.OIDCClientSecret, "oidc_client_secret", os.Getenv("OIDC_CLIENT_SECRET"), "openid connect client secret")
+	flag.StringVar(&config.HashSalt, "hash_salt", "", "hash salt used for sign in tokens, read from keys_dir if empty")
 	flag.StringVar(&config.SignKey, "sign_key", "", "path to sign key")
 	flag.StringVar(&config.VerifyKey, "verify_key", "", "path to verify key")
+	flag.StringVar(&config.KeysDir, "keys_dir", "", "path to keys dir made by writegood keygen, used instead of sign_key and verify_key")
 
 	flag.Parse()
 
-	log.Printf("[info] config:\n%s", spew.Sdump(config))
+	if *signUpDomains != "" {
+		config.SignUpDomains = strings.Split(*signUpDomains, ",")
+	}
+
+	log.Printf("[info] config:\n%s", spew.Sdump(config.Redacted()))
 
 	s := &server.Server{
 		Config: config,
@@ -39,3 +77,33 @@ func main() {
 		log.Fatalf("[error] server failed to run: %v", err)
 	}
 }
+
+// keygenCommand creates the keys and salt a new deployment needs.
+func keygenCommand(args []string) {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	dir := flags.String("keys_dir", "keys", "path to keys dir to create")
+	_ = flags.Parse(args)
+
+	kid, err := keys.Generate(*dir)
+	if err != nil {
+		log.Fatalf("[error] failed to generate keys: %v", err)
+	}
+	log.Printf("[info] generated keys in: %s, key id: %s, run the server with -keys_dir=%s", *dir, kid, *dir)
+}
+
+// keysCommand runs the keys subcommands.
+func keysCommand(args []string) {
+	if len(args) == 0 || args[0] != "rotate" {
+		log.Fatalf("[error] usage: writegood keys rotate [-keys_dir dir] [-keep n]")
+	}
+	flags := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	dir := flags.String("keys_dir", "keys", "path to keys dir, created if it doesn't exist")
+	keep := flags.Int("keep", 2, "number of previous keys to keep so existing tokens and sessions keep working")
+	_ = flags.Parse(args[1:])
+
+	kid, err := keys.Rotate(*dir, *keep)
+	if err != nil {
+		log.Fatalf("[error] failed to rotate keys: %v", err)
+	}
+	log.Printf("[info] rotated keys in: %s, new key id: %s, restart servers to start using it", *dir, kid)
+}
@@ -0,0 +1,5 @@
+DROP INDEX DOCUMENTS_TAGS;
+DROP INDEX DOCUMENTS_AUTHOR_ID_TITLE;
+DROP INDEX DOCUMENTS_AUTHOR_ID_UPDATED;
+DROP INDEX DOCUMENTS_AUTHOR_ID_CREATED;
+ALTER TABLE DOCUMENTS DROP COLUMN HEADING;
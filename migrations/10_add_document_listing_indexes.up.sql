@@ -0,0 +1,6 @@
+ALTER TABLE DOCUMENTS ADD COLUMN HEADING text NOT NULL DEFAULT '';
+UPDATE DOCUMENTS SET HEADING = COALESCE(SUBSTRING(TEXT FROM '(?n)^[ \t]*#{1,6}[ \t]+([^\n]*[^ \t#\n])'), '');
+CREATE INDEX DOCUMENTS_AUTHOR_ID_CREATED ON DOCUMENTS (AUTHOR_ID, CREATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_UPDATED ON DOCUMENTS (AUTHOR_ID, UPDATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_TITLE ON DOCUMENTS (AUTHOR_ID, LOWER(COALESCE(NULLIF(TITLE, ''), HEADING)), ID);
+CREATE INDEX DOCUMENTS_TAGS ON DOCUMENTS USING GIN (TAGS);
@@ -0,0 +1 @@
+DROP INDEX DOCUMENTS_SEARCH;
@@ -0,0 +1 @@
+CREATE INDEX DOCUMENTS_SEARCH ON DOCUMENTS USING GIN (TO_TSVECTOR('english', TITLE || ' ' || COALESCE(TEXT, '')));
@@ -0,0 +1,2 @@
+ALTER TABLE DOCUMENTS DROP COLUMN FOLDER_ID;
+DROP TABLE FOLDERS;
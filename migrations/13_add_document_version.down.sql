@@ -0,0 +1 @@
+ALTER TABLE DOCUMENTS DROP COLUMN VERSION;
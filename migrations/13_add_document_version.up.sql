@@ -0,0 +1 @@
+ALTER TABLE DOCUMENTS ADD COLUMN VERSION integer NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE DOCUMENTS DROP CONSTRAINT DOCUMENTS_PKEY;
@@ -0,0 +1 @@
+ALTER TABLE DOCUMENTS ADD PRIMARY KEY (ID);
@@ -0,0 +1,7 @@
+CREATE TABLE DOCUMENT_PERMISSIONS (DOCUMENT_ID integer NOT NULL REFERENCES DOCUMENTS (ID) ON DELETE CASCADE,
+                                   USER_ID integer NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE,
+                                   ROLE text NOT NULL CHECK (ROLE IN ('viewer', 'commenter', 'editor', 'owner')),
+                                   CREATED TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   UPDATED TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   PRIMARY KEY (DOCUMENT_ID, USER_ID));
+CREATE INDEX DOCUMENT_PERMISSIONS_USER_ID ON DOCUMENT_PERMISSIONS (USER_ID);
@@ -0,0 +1 @@
+DROP TABLE SHARE_LINKS;
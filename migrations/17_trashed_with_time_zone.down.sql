@@ -0,0 +1 @@
+ALTER TABLE DOCUMENTS ALTER COLUMN TRASHED TYPE TIMESTAMP;
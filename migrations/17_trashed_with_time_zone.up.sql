@@ -0,0 +1,2 @@
+-- TRASHED was set from the server's local time, which is usually the database's time zone too.
+ALTER TABLE DOCUMENTS ALTER COLUMN TRASHED TYPE TIMESTAMP WITH TIME ZONE;
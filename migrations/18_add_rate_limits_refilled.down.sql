@@ -0,0 +1,2 @@
+DROP INDEX RATE_LIMITS_REFILLED;
+ALTER TABLE RATE_LIMITS DROP COLUMN REFILLED;
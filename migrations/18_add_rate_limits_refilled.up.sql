@@ -0,0 +1,5 @@
+-- the server's limiters all refill completely in an hour.
+ALTER TABLE RATE_LIMITS ADD COLUMN REFILLED TIMESTAMP WITH TIME ZONE;
+UPDATE RATE_LIMITS SET REFILLED = COALESCE(UPDATED, CURRENT_TIMESTAMP) + INTERVAL '1 hour';
+ALTER TABLE RATE_LIMITS ALTER COLUMN REFILLED SET NOT NULL;
+CREATE INDEX RATE_LIMITS_REFILLED ON RATE_LIMITS (REFILLED);
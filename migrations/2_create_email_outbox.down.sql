@@ -0,0 +1 @@
+DROP TABLE EMAIL_OUTBOX;
@@ -0,0 +1,15 @@
+CREATE TABLE EMAIL_OUTBOX (ID serial PRIMARY KEY,
+                           FROM_NAME text,
+                           FROM_ACCOUNT text,
+                           TO_ACCOUNT text,
+                           SUBJECT text,
+                           PLAIN text,
+                           HTML text,
+                           STATUS text NOT NULL DEFAULT 'pending',
+                           ATTEMPTS integer NOT NULL DEFAULT 0,
+                           LAST_ERROR text,
+                           NEXT_ATTEMPT TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                           SENT TIMESTAMP WITH TIME ZONE,
+                           CREATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                           UPDATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX EMAIL_OUTBOX_PENDING ON EMAIL_OUTBOX (NEXT_ATTEMPT) WHERE STATUS = 'pending';
@@ -0,0 +1 @@
+DROP TABLE RATE_LIMITS;
@@ -0,0 +1,4 @@
+CREATE TABLE RATE_LIMITS (KEY text PRIMARY KEY,
+                          TOKENS double precision NOT NULL,
+                          ALLOWED boolean NOT NULL,
+                          UPDATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP);
@@ -0,0 +1,8 @@
+CREATE TABLE API_TOKENS (ID serial PRIMARY KEY,
+                         USER_ID integer NOT NULL REFERENCES USERS (ID),
+                         NAME text NOT NULL,
+                         HASH text NOT NULL UNIQUE,
+                         SCOPE text NOT NULL,
+                         CREATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                         LAST_USED TIMESTAMP WITH TIME ZONE,
+                         REVOKED TIMESTAMP WITH TIME ZONE);
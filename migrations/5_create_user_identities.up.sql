@@ -0,0 +1,6 @@
+CREATE TABLE USER_IDENTITIES (PROVIDER text NOT NULL,
+                              SUBJECT text NOT NULL,
+                              USER_ID integer NOT NULL REFERENCES USERS (ID),
+                              EMAIL text,
+                              CREATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                              PRIMARY KEY (PROVIDER, SUBJECT));
@@ -0,0 +1 @@
+DROP TABLE WEBAUTHN_CREDENTIALS;
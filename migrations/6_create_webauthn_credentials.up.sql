@@ -0,0 +1,8 @@
+CREATE TABLE WEBAUTHN_CREDENTIALS (ID bytea PRIMARY KEY,
+                                   USER_ID integer NOT NULL REFERENCES USERS (ID),
+                                   NAME text,
+                                   PUBLIC_KEY bytea NOT NULL,
+                                   SIGN_COUNT bigint NOT NULL DEFAULT 0,
+                                   CREATED TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                                   LAST_USED TIMESTAMP WITH TIME ZONE);
+CREATE INDEX WEBAUTHN_CREDENTIALS_USER_ID ON WEBAUTHN_CREDENTIALS (USER_ID);
@@ -0,0 +1 @@
+DROP INDEX USERS_EMAIL_KEY;
@@ -0,0 +1,15 @@
+DO $$
+DECLARE DUPLICATES text;
+BEGIN
+  SELECT STRING_AGG(EMAILS, '; ') INTO DUPLICATES
+  FROM (SELECT STRING_AGG(EMAIL || ' (id ' || ID || ')', ', ' ORDER BY ID) AS EMAILS
+        FROM USERS
+        WHERE EMAIL IS NOT NULL
+        GROUP BY LOWER(TRIM(EMAIL))
+        HAVING COUNT(*) > 1) AS D;
+  IF DUPLICATES IS NOT NULL THEN
+    RAISE EXCEPTION 'users share emails that only differ by case or spaces, merge or rename them, force the migration version back to 6 and migrate again: %', DUPLICATES;
+  END IF;
+END $$;
+UPDATE USERS SET EMAIL = LOWER(TRIM(EMAIL));
+CREATE UNIQUE INDEX USERS_EMAIL_KEY ON USERS (LOWER(EMAIL));
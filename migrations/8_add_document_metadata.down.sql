@@ -0,0 +1,5 @@
+ALTER TABLE DOCUMENTS DROP COLUMN TITLE,
+                      DROP COLUMN SLUG,
+                      DROP COLUMN DESCRIPTION,
+                      DROP COLUMN TAGS,
+                      DROP COLUMN METADATA;
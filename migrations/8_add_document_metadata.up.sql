@@ -0,0 +1,5 @@
+ALTER TABLE DOCUMENTS ADD COLUMN TITLE text NOT NULL DEFAULT '',
+                      ADD COLUMN SLUG text NOT NULL DEFAULT '',
+                      ADD COLUMN DESCRIPTION text NOT NULL DEFAULT '',
+                      ADD COLUMN TAGS text[] NOT NULL DEFAULT '{}',
+                      ADD COLUMN METADATA jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+DROP INDEX DOCUMENTS_TRASHED;
+ALTER TABLE DOCUMENTS DROP COLUMN STATE,
+                      DROP COLUMN TRASHED;
@@ -0,0 +1,3 @@
+ALTER TABLE DOCUMENTS ADD COLUMN STATE text NOT NULL DEFAULT 'active',
+                      ADD COLUMN TRASHED TIMESTAMP;
+CREATE INDEX DOCUMENTS_TRASHED ON DOCUMENTS (TRASHED) WHERE STATE = 'trashed';
@@ -0,0 +1 @@
+-- the times are left in UTC, they can't be converted back to the offsets they had.
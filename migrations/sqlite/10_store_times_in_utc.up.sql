@@ -0,0 +1,20 @@
+-- times were stored with the server's offset, they're converted to UTC so they compare as text.
+UPDATE USERS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE USERS SET UPDATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', UPDATED) WHERE UPDATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND UPDATED NOT GLOB '*+00:00';
+UPDATE USERS SET SIGNED_IN = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', SIGNED_IN) WHERE SIGNED_IN GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND SIGNED_IN NOT GLOB '*+00:00';
+UPDATE DOCUMENTS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE DOCUMENTS SET UPDATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', UPDATED) WHERE UPDATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND UPDATED NOT GLOB '*+00:00';
+UPDATE DOCUMENTS SET TRASHED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', TRASHED) WHERE TRASHED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND TRASHED NOT GLOB '*+00:00';
+UPDATE FOLDERS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE FOLDERS SET UPDATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', UPDATED) WHERE UPDATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND UPDATED NOT GLOB '*+00:00';
+UPDATE DOCUMENT_PERMISSIONS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE DOCUMENT_PERMISSIONS SET UPDATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', UPDATED) WHERE UPDATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND UPDATED NOT GLOB '*+00:00';
+UPDATE SHARE_LINKS SET EXPIRES = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', EXPIRES) WHERE EXPIRES GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND EXPIRES NOT GLOB '*+00:00';
+UPDATE SHARE_LINKS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE SHARE_LINKS SET REVOKED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', REVOKED) WHERE REVOKED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND REVOKED NOT GLOB '*+00:00';
+UPDATE API_TOKENS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE API_TOKENS SET LAST_USED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', LAST_USED) WHERE LAST_USED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND LAST_USED NOT GLOB '*+00:00';
+UPDATE API_TOKENS SET REVOKED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', REVOKED) WHERE REVOKED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND REVOKED NOT GLOB '*+00:00';
+UPDATE USER_IDENTITIES SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE WEBAUTHN_CREDENTIALS SET CREATED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', CREATED) WHERE CREATED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND CREATED NOT GLOB '*+00:00';
+UPDATE WEBAUTHN_CREDENTIALS SET LAST_USED = STRFTIME('%Y-%m-%d %H:%M:%f+00:00', LAST_USED) WHERE LAST_USED GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND LAST_USED NOT GLOB '*+00:00';
@@ -0,0 +1,2 @@
+DROP TABLE DOCUMENTS;
+DROP TABLE USERS;
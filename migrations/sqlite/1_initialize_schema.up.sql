@@ -0,0 +1,12 @@
+CREATE TABLE USERS (ID integer PRIMARY KEY AUTOINCREMENT,
+                    EMAIL text NOT NULL,
+                    CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    UPDATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    SIGNED_IN TIMESTAMP);
+CREATE UNIQUE INDEX USERS_EMAIL_KEY ON USERS (LOWER(EMAIL));
+CREATE TABLE DOCUMENTS (ID integer PRIMARY KEY AUTOINCREMENT,
+                        AUTHOR_ID integer NOT NULL REFERENCES USERS (ID),
+                        TEXT text,
+                        CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                        UPDATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX DOCUMENTS_AUTHOR_ID ON DOCUMENTS (AUTHOR_ID);
@@ -0,0 +1,9 @@
+CREATE TABLE DOCUMENTS_OLD (ID integer PRIMARY KEY AUTOINCREMENT,
+                            AUTHOR_ID integer NOT NULL REFERENCES USERS (ID),
+                            TEXT text,
+                            CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            UPDATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+INSERT INTO DOCUMENTS_OLD (ID, AUTHOR_ID, TEXT, CREATED, UPDATED) SELECT ID, AUTHOR_ID, TEXT, CREATED, UPDATED FROM DOCUMENTS;
+DROP TABLE DOCUMENTS;
+ALTER TABLE DOCUMENTS_OLD RENAME TO DOCUMENTS;
+CREATE INDEX DOCUMENTS_AUTHOR_ID ON DOCUMENTS (AUTHOR_ID);
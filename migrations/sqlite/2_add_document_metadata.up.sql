@@ -0,0 +1,5 @@
+ALTER TABLE DOCUMENTS ADD COLUMN TITLE text NOT NULL DEFAULT '';
+ALTER TABLE DOCUMENTS ADD COLUMN SLUG text NOT NULL DEFAULT '';
+ALTER TABLE DOCUMENTS ADD COLUMN DESCRIPTION text NOT NULL DEFAULT '';
+ALTER TABLE DOCUMENTS ADD COLUMN TAGS text NOT NULL DEFAULT '[]';
+ALTER TABLE DOCUMENTS ADD COLUMN METADATA text NOT NULL DEFAULT '{}';
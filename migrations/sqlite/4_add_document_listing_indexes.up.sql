@@ -0,0 +1,5 @@
+-- there's no regexp in sqlite so existing documents' headings are set when they're next saved.
+ALTER TABLE DOCUMENTS ADD COLUMN HEADING text NOT NULL DEFAULT '';
+CREATE INDEX DOCUMENTS_AUTHOR_ID_CREATED ON DOCUMENTS (AUTHOR_ID, CREATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_UPDATED ON DOCUMENTS (AUTHOR_ID, UPDATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_TITLE ON DOCUMENTS (AUTHOR_ID, LOWER(COALESCE(NULLIF(TITLE, ''), HEADING)), ID);
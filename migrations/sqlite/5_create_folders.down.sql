@@ -0,0 +1,24 @@
+DROP INDEX DOCUMENTS_FOLDER_ID;
+CREATE TABLE DOCUMENTS_OLD (ID integer PRIMARY KEY AUTOINCREMENT,
+                            AUTHOR_ID integer NOT NULL REFERENCES USERS (ID),
+                            TEXT text,
+                            CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            UPDATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                            TITLE text NOT NULL DEFAULT '',
+                            SLUG text NOT NULL DEFAULT '',
+                            DESCRIPTION text NOT NULL DEFAULT '',
+                            TAGS text NOT NULL DEFAULT '[]',
+                            METADATA text NOT NULL DEFAULT '{}',
+                            STATE text NOT NULL DEFAULT 'active',
+                            TRASHED TIMESTAMP,
+                            HEADING text NOT NULL DEFAULT '');
+INSERT INTO DOCUMENTS_OLD (ID, AUTHOR_ID, TEXT, CREATED, UPDATED, TITLE, SLUG, DESCRIPTION, TAGS, METADATA, STATE, TRASHED, HEADING)
+SELECT ID, AUTHOR_ID, TEXT, CREATED, UPDATED, TITLE, SLUG, DESCRIPTION, TAGS, METADATA, STATE, TRASHED, HEADING FROM DOCUMENTS;
+DROP TABLE DOCUMENTS;
+ALTER TABLE DOCUMENTS_OLD RENAME TO DOCUMENTS;
+CREATE INDEX DOCUMENTS_AUTHOR_ID ON DOCUMENTS (AUTHOR_ID);
+CREATE INDEX DOCUMENTS_TRASHED ON DOCUMENTS (TRASHED) WHERE STATE = 'trashed';
+CREATE INDEX DOCUMENTS_AUTHOR_ID_CREATED ON DOCUMENTS (AUTHOR_ID, CREATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_UPDATED ON DOCUMENTS (AUTHOR_ID, UPDATED, ID);
+CREATE INDEX DOCUMENTS_AUTHOR_ID_TITLE ON DOCUMENTS (AUTHOR_ID, LOWER(COALESCE(NULLIF(TITLE, ''), HEADING)), ID);
+DROP TABLE FOLDERS;
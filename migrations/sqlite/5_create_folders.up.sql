@@ -0,0 +1,10 @@
+CREATE TABLE FOLDERS (ID integer PRIMARY KEY AUTOINCREMENT,
+                      OWNER_ID integer NOT NULL REFERENCES USERS (ID),
+                      PARENT_ID integer REFERENCES FOLDERS (ID),
+                      NAME text NOT NULL,
+                      CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                      UPDATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX FOLDERS_OWNER_ID ON FOLDERS (OWNER_ID);
+CREATE INDEX FOLDERS_PARENT_ID ON FOLDERS (PARENT_ID);
+ALTER TABLE DOCUMENTS ADD COLUMN FOLDER_ID integer REFERENCES FOLDERS (ID);
+CREATE INDEX DOCUMENTS_FOLDER_ID ON DOCUMENTS (FOLDER_ID);
@@ -0,0 +1 @@
+DROP TABLE DOCUMENT_PERMISSIONS;
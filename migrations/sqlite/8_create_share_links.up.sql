@@ -0,0 +1,9 @@
+CREATE TABLE SHARE_LINKS (ID integer PRIMARY KEY AUTOINCREMENT,
+                          DOCUMENT_ID integer NOT NULL REFERENCES DOCUMENTS (ID) ON DELETE CASCADE,
+                          CREATED_BY integer NOT NULL REFERENCES USERS (ID) ON DELETE CASCADE,
+                          HASH text NOT NULL UNIQUE,
+                          PASSWORD_HASH text NOT NULL DEFAULT '',
+                          EXPIRES TIMESTAMP,
+                          CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                          REVOKED TIMESTAMP);
+CREATE INDEX SHARE_LINKS_DOCUMENT_ID ON SHARE_LINKS (DOCUMENT_ID);
@@ -0,0 +1,3 @@
+DROP TABLE WEBAUTHN_CREDENTIALS;
+DROP TABLE USER_IDENTITIES;
+DROP TABLE API_TOKENS;
@@ -0,0 +1,22 @@
+CREATE TABLE API_TOKENS (ID integer PRIMARY KEY AUTOINCREMENT,
+                         USER_ID integer NOT NULL REFERENCES USERS (ID),
+                         NAME text NOT NULL,
+                         HASH text NOT NULL UNIQUE,
+                         SCOPE text NOT NULL,
+                         CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                         LAST_USED TIMESTAMP,
+                         REVOKED TIMESTAMP);
+CREATE TABLE USER_IDENTITIES (PROVIDER text NOT NULL,
+                              SUBJECT text NOT NULL,
+                              USER_ID integer NOT NULL REFERENCES USERS (ID),
+                              EMAIL text,
+                              CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                              PRIMARY KEY (PROVIDER, SUBJECT));
+CREATE TABLE WEBAUTHN_CREDENTIALS (ID blob PRIMARY KEY,
+                                   USER_ID integer NOT NULL REFERENCES USERS (ID),
+                                   NAME text NOT NULL DEFAULT '',
+                                   PUBLIC_KEY blob NOT NULL,
+                                   SIGN_COUNT integer NOT NULL DEFAULT 0,
+                                   CREATED TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                   LAST_USED TIMESTAMP);
+CREATE INDEX WEBAUTHN_CREDENTIALS_USER_ID ON WEBAUTHN_CREDENTIALS (USER_ID);
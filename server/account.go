@@ -0,0 +1,236 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// deleteAccountAudience marks tokens that confirm deleting an account so they can't be used for
+// anything else.
+const deleteAccountAudience = "delete_account"
+
+// DeleteAccountClaims are the claims of the token emailed to confirm deleting an account. The
+// token's only good while the user has the email it was sent to.
+type DeleteAccountClaims struct {
+	UserID int
+	Email  string
+	jwt.StandardClaims
+}
+
+// requestAccountDeletion emails the user a link to confirm deleting their account.
+func (s *Server) requestAccountDeletion(ctx context.Context, user User) error {
+	now := time.Now()
+	signedToken, err := s.signToken(DeleteAccountClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  deleteAccountAudience,
+			Issuer:    "Write Good",
+			Id:        uuid.NewV4().String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.Config.SignInExpire).Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Token  string
+		Domain string
+	}{
+		Token:  signedToken,
+		Domain: s.Config.Domain,
+	}
+	if err = s.sendEmail(ctx, user.Email, "Confirm deleting your Write Good account", "delete_account", data); err != nil {
+		return err
+	}
+	log.Printf("[debug] queued delete account email for user: %d", user.ID)
+	return nil
+}
+
+// verifyDeleteAccountToken returns the user the delete account token is for.
+func (s *Server) verifyDeleteAccountToken(ctx context.Context, token string) (User, error) {
+	var claims DeleteAccountClaims
+	if err := s.parseToken(token, &claims); err != nil {
+		return User{}, fmt.Errorf("failed to parse delete account token: %v", err)
+	}
+	if !claims.VerifyAudience(deleteAccountAudience, true) {
+		return User{}, fmt.Errorf("failed to verify delete account claims for user: %d", claims.UserID)
+	}
+	user, err := s.Users.FindUserByID(ctx, claims.UserID)
+	if err != nil {
+		return User{}, fmt.Errorf("failed to find user to delete: %d: %v", claims.UserID, err)
+	}
+	if user.Email != claims.Email {
+		return User{}, fmt.Errorf("user to delete changed their email: %d", claims.UserID)
+	}
+	return user, nil
+}
+
+// HandleDeleteAccountVerify asks the user to confirm deleting their account when they open a
+// delete account confirmation link, then deletes it and signs them out when they confirm. Mail
+// scanners and link prefetchers open links so opening one mustn't delete anything.
+func (s *Server) HandleDeleteAccountVerify(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	token := r.Form.Get("token")
+	user, err := s.verifyDeleteAccountToken(r.Context(), token)
+	if err != nil {
+		log.Printf("[error] %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		data := struct {
+			Token string
+			Email string
+		}{
+			Token: token,
+			Email: user.Email,
+		}
+		if err = s.templates.Lookup("confirm_delete_account.html").Execute(w, data); err != nil {
+			log.Printf("[error] failed to execute template: %v", err)
+		}
+		return
+	}
+
+	if err = s.Users.DeleteUser(r.Context(), user); err != nil {
+		log.Printf("[error] failed to delete user: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[info] deleted user: %d", user.ID)
+	s.collab.evictUser(user.ID)
+
+	// sessions of deleted users are ignored, this clears the cookie too.
+	session, err := s.sessions.Get(r, userSession)
+	if err == nil {
+		session.Options.MaxAge = -1
+		if err = s.sessions.Save(r, w, session); err != nil {
+			log.Printf("[error] failed to save session: %v", err)
+		}
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// exportManifest describes the account in a data export. Document texts are in their own files.
+type exportManifest struct {
+	Exported   time.Time            `json:"exported"`
+	User       User                 `json:"user"`
+	Documents  []exportDocument     `json:"documents"`
+	Folders    []Folder             `json:"folders"`
+	APITokens  []APIToken           `json:"api_tokens"`
+	Identities []UserIdentity       `json:"identities"`
+	Passkeys   []WebAuthnCredential `json:"passkeys"`
+}
+
+type exportDocument struct {
+	ID          int                    `json:"id"`
+	File        string                 `json:"file"`
+	Title       string                 `json:"title"`
+	Slug        string                 `json:"slug"`
+	Description string                 `json:"description"`
+	Tags        []string               `json:"tags"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	State       DocumentState          `json:"state"`
+	FolderID    *int                   `json:"folder_id"`
+	Created     time.Time              `json:"created"`
+	Updated     time.Time              `json:"updated"`
+}
+
+// HandleExportMyData responds with a ZIP of the viewer's documents, one text file each, and a
+// manifest.json of their account's metadata.
+func (s *Server) HandleExportMyData(w http.ResponseWriter, r *http.Request) {
+	viewer, err := s.authenticate(r)
+	if err != nil || viewer == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	user := viewer.User
+
+	documents, err := s.Documents.FindDocumentsByAuthor(r.Context(), user.ID, DocumentFilter{})
+	if err != nil {
+		log.Printf("[error] failed to find documents: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	manifest := exportManifest{
+		Exported: time.Now(),
+		User:     user,
+	}
+	if manifest.Folders, err = s.Folders.FindFoldersByOwner(r.Context(), user.ID); err != nil {
+		log.Printf("[error] failed to find folders: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if manifest.APITokens, err = s.Credentials.FindAPITokensByUser(r.Context(), user.ID); err != nil {
+		log.Printf("[error] failed to find api tokens: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if manifest.Identities, err = s.Credentials.FindIdentitiesByUser(r.Context(), user.ID); err != nil {
+		log.Printf("[error] failed to find identities: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if manifest.Passkeys, err = s.Credentials.FindWebAuthnCredentialsByUser(r.Context(), user.ID); err != nil {
+		log.Printf("[error] failed to find passkeys: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="writegood-export.zip"`)
+	zw := zip.NewWriter(w)
+	for _, d := range documents {
+		file := fmt.Sprintf("documents/%d.txt", d.ID)
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: d.Updated})
+		if err != nil {
+			log.Printf("[error] failed to write export: %v", err)
+			return
+		}
+		if _, err = f.Write([]byte(d.Text)); err != nil {
+			log.Printf("[error] failed to write export: %v", err)
+			return
+		}
+		manifest.Documents = append(manifest.Documents, exportDocument{
+			ID:          d.ID,
+			File:        file,
+			Title:       documentTitle(d),
+			Slug:        documentSlug(d),
+			Description: d.Description,
+			Tags:        d.Tags,
+			Metadata:    d.Metadata,
+			State:       d.State,
+			FolderID:    d.FolderID,
+			Created:     d.Created,
+			Updated:     d.Updated,
+		})
+	}
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		log.Printf("[error] failed to write export: %v", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(manifest); err != nil {
+		log.Printf("[error] failed to write export: %v", err)
+		return
+	}
+	if err = zw.Close(); err != nil {
+		log.Printf("[error] failed to write export: %v", err)
+	}
+	log.Printf("[debug] exported data of user: %d", user.ID)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix makes the tokens easy to recognize, e.g. by secret scanners.
+const apiTokenPrefix = "wg_"
+
+var errInvalidAPIToken = errors.New("invalid api token")
+
+// APIToken is a personal token used to call the API without a browser session. Only the token's
+// hash is stored, Token is only set when it's created.
+type APIToken struct {
+	ID       int        `json:"id"`
+	UserID   int        `json:"user_id"`
+	Name     string     `json:"name"`
+	Scope    string     `json:"scope"`
+	Token    string     `json:"token,omitempty"`
+	Hash     string     `json:"-"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+	Revoked  *time.Time `json:"revoked"`
+}
+
+// hashToken returns the hash of the token we store and look tokens up by. The tokens are random
+// so they don't need a salt or a slow hash.
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// generateToken returns a random token starting with the prefix.
+func generateToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + strings.ToLower(strings.TrimRight(base32.StdEncoding.EncodeToString(b), "=")), nil
+}
+
+// CreateAPIToken creates a token for the user with the scope.
+func (s *Server) CreateAPIToken(ctx context.Context, userID int, name, scope string) (APIToken, error) {
+	if scope != scopeRead && scope != scopeWrite {
+		return APIToken{}, fmt.Errorf("invalid scope: %s", scope)
+	}
+	token, err := generateToken(apiTokenPrefix)
+	if err != nil {
+		return APIToken{}, err
+	}
+	t, err := s.Credentials.CreateAPIToken(ctx, APIToken{UserID: userID, Name: name, Scope: scope, Hash: hashToken(token)})
+	if err != nil {
+		return t, err
+	}
+	t.Token = token
+	return t, nil
+}
+
+// AuthenticateAPIToken returns the unrevoked token matching the given token and marks it used.
+func (s *Server) AuthenticateAPIToken(ctx context.Context, token string) (APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return APIToken{}, errInvalidAPIToken
+	}
+	t, err := s.Credentials.UseAPIToken(ctx, hashToken(token))
+	if err == ErrNotFound {
+		return t, errInvalidAPIToken
+	}
+	return t, err
+}
@@ -0,0 +1,612 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/travisjeffery/writegood/server/ot"
+)
+
+const (
+	// snapshotInterval is how often a room's edits are saved to its document while it's open.
+	snapshotInterval = 10 * time.Second
+	// snapshotAttempts is how many times a snapshot merges changes made outside the room and retries.
+	snapshotAttempts = 3
+	collabSendBuffer = 64
+	// collabHistoryLimit is how many operations a room keeps to transform the ones clients make at
+	// earlier revisions, clients further behind have to rejoin.
+	collabHistoryLimit = 1000
+)
+
+// limits for the WebSocket connections, clients are pinged so dropped ones are noticed.
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 512 << 10
+)
+
+var (
+	errInvalidRevision = errors.New("revision is ahead of the document")
+	errStaleRevision   = errors.New("revision is too far behind the document, rejoin it")
+	errInvalidMessage  = errors.New("invalid message")
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// collabMessage is sent between the server and clients editing a document. Clients send:
+//
+// - op: an operation made to the document at the revision.
+// - cursor: where the client's cursor is in the document at the revision.
+//
+// And the server sends:
+//
+// - init: the document's text and revision and who's editing it, when the client joins.
+// - ack: the client's operation was applied and made the revision.
+// - op: another client's operation, transformed to apply after the previous revision.
+// - cursor: another client's cursor at the revision.
+// - presence: who's editing the document, when someone joins or leaves.
+// - error: the client's message couldn't be applied, the connection is closed after.
+type collabMessage struct {
+	Type     string           `json:"type"`
+	Revision int              `json:"revision"`
+	Ops      ot.Operation     `json:"ops,omitempty"`
+	Text     *string          `json:"text,omitempty"`
+	ClientID int              `json:"client_id,omitempty"`
+	Cursor   *collabCursor    `json:"cursor,omitempty"`
+	Clients  []collabPresence `json:"clients,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// collabCursor is a client's cursor and the end of its selection, which is the same as the
+// position when nothing's selected.
+type collabCursor struct {
+	Position     int `json:"position"`
+	SelectionEnd int `json:"selection_end"`
+}
+
+// collabPresence is a client editing a document.
+type collabPresence struct {
+	ClientID int           `json:"client_id"`
+	UserID   int           `json:"user_id"`
+	Email    string        `json:"email"`
+	Cursor   *collabCursor `json:"cursor"`
+}
+
+// collabHub has the rooms of the documents being edited.
+type collabHub struct {
+	mu    sync.Mutex
+	rooms map[int]*collabRoom
+	// closing has the rooms that were left and are being saved, their channels are closed once
+	// they're saved.
+	closing map[int]chan struct{}
+	publish func(Document)
+}
+
+// newCollabHub returns a hub that calls publish with the documents its rooms save.
+func newCollabHub(publish func(Document)) *collabHub {
+	return &collabHub{rooms: make(map[int]*collabRoom), closing: make(map[int]chan struct{}), publish: publish}
+}
+
+// collabRoom is a document being edited by one or more clients. The room's text is the source of
+// truth while it's open: operations are transformed against the ones made since the revision the
+// client made them at and applied in the order they're received, and the text is saved to the
+// document every snapshotInterval and when the last client leaves.
+type collabRoom struct {
+	mu        sync.Mutex
+	documents DocumentStore
+	publish   func(Document)
+	id        int
+	authorID  int
+	text      string
+	// history has the operations made since the base revision that clients may still make theirs
+	// at.
+	history  []ot.Operation
+	base     int
+	clients  map[int]*collabClient
+	clientID int
+	done     chan struct{}
+
+	// saved is the text at the document's version, unsaved are the operations made to it since.
+	saved   string
+	version int
+	unsaved []ot.Operation
+}
+
+// collabClient is a connection editing a document.
+type collabClient struct {
+	id     int
+	user   User
+	conn   *websocket.Conn
+	send   chan collabMessage
+	cursor *collabCursor
+	closed bool
+	// revision is the latest revision the client's made a change at, or joined at.
+	revision int
+	// authorize returns an error unless the user is still one of the document's editors.
+	authorize func() error
+}
+
+// HandleCollaborate upgrades the request to a WebSocket editing the document with everyone else
+// editing it. The viewer must be one of the document's editors, they're evicted from the room if
+// they stop being one rather than checking their role for each operation.
+func (s *Server) HandleCollaborate(w http.ResponseWriter, r *http.Request) {
+	viewer, err := s.authenticate(r)
+	if err != nil || viewer == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if viewer.Scope != scopeWrite {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, err = s.authorizeDocument(withViewer(r.Context(), viewer), id, RoleEditor)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == errForbidden {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to find document: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the upgrader responds with the error itself.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &collabClient{
+		user: viewer.User,
+		conn: conn,
+		send: make(chan collabMessage, collabSendBuffer),
+	}
+	go c.writeLoop()
+	room, err := s.collab.join(r.Context(), s.Documents, id, c)
+	if err != nil {
+		log.Printf("[error] failed to join document: %v", err)
+		c.close()
+		return
+	}
+	defer s.collab.leave(room, c)
+	// a bad message mustn't leave the client in the room, or take the server down.
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("[error] panic handling collaboration on document: %d: %v", id, v)
+		}
+	}()
+	// the client's evicted if they stop being an editor while they're in the room, this catches
+	// them losing the role before they joined it.
+	if _, err = s.authorizeDocument(withViewer(r.Context(), viewer), id, RoleEditor); err != nil {
+		room.fail(c, errForbidden)
+		return
+	}
+	c.readLoop(room)
+}
+
+// join adds the client to the document's room, opening it with the saved document if no one's
+// editing it. If the room's being saved after the last client left, it's opened again once it's
+// saved.
+func (h *collabHub) join(ctx context.Context, documents DocumentStore, id int, c *collabClient) (*collabRoom, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[id]
+	for !ok {
+		if saved, closing := h.closing[id]; closing {
+			h.mu.Unlock()
+			<-saved
+			h.mu.Lock()
+			room, ok = h.rooms[id]
+			continue
+		}
+		// the document's found without the hub locked, someone else may open the room meanwhile.
+		h.mu.Unlock()
+		d, err := documents.FindDocumentByID(ctx, id)
+		h.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		if room, ok = h.rooms[id]; ok {
+			break
+		}
+		if _, closing := h.closing[id]; closing {
+			continue
+		}
+		room = &collabRoom{
+			documents: documents,
+			publish:   h.publish,
+			id:        d.ID,
+			authorID:  d.AuthorID,
+			text:      d.Text,
+			clients:   make(map[int]*collabClient),
+			done:      make(chan struct{}),
+			saved:     d.Text,
+			version:   d.Version,
+		}
+		h.rooms[d.ID] = room
+		go room.run()
+		ok = true
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.clientID++
+	c.id = room.clientID
+	c.revision = room.revision()
+	room.clients[c.id] = c
+	// the message is sent from another goroutine so it gets a copy of the text.
+	text := room.text
+	c.queue(collabMessage{
+		Type:     "init",
+		Revision: room.revision(),
+		Text:     &text,
+		ClientID: c.id,
+		Clients:  room.presence(),
+	})
+	room.broadcast(c, collabMessage{Type: "presence", Revision: room.revision(), Clients: room.presence()})
+	log.Printf("[debug] user %d joined document: %d", c.user.ID, id)
+	return room, nil
+}
+
+// leave removes the client from the room, closing and snapshotting it if they were the last one in
+// it. The snapshot's saved after the hub's unlocked so other documents' rooms aren't held up.
+func (h *collabHub) leave(room *collabRoom, c *collabClient) {
+	h.mu.Lock()
+	room.mu.Lock()
+	delete(room.clients, c.id)
+	c.close()
+	log.Printf("[debug] user %d left document: %d", c.user.ID, room.id)
+	if len(room.clients) > 0 {
+		room.broadcast(nil, collabMessage{Type: "presence", Revision: room.revision(), Clients: room.presence()})
+		room.mu.Unlock()
+		h.mu.Unlock()
+		return
+	}
+	delete(h.rooms, room.id)
+	saved := make(chan struct{})
+	h.closing[room.id] = saved
+	close(room.done)
+	h.mu.Unlock()
+
+	if err := room.snapshot(context.Background()); err != nil {
+		log.Printf("[error] failed to snapshot document: %v", err)
+	}
+	room.mu.Unlock()
+
+	h.mu.Lock()
+	delete(h.closing, room.id)
+	h.mu.Unlock()
+	close(saved)
+}
+
+// evict disconnects the user's clients from the document's room, e.g. when it's unshared with them.
+func (h *collabHub) evict(documentID, userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[documentID]
+	if !ok {
+		return
+	}
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.evict(func(c *collabClient) bool { return c.user.ID == userID })
+}
+
+// evictUser disconnects the user's clients from every room, and everyone from the rooms of the
+// documents they wrote, when their account's deleted.
+func (h *collabHub) evictUser(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, room := range h.rooms {
+		room.mu.Lock()
+		room.evict(func(c *collabClient) bool { return room.authorID == userID || c.user.ID == userID })
+		room.mu.Unlock()
+	}
+}
+
+// run snapshots the room every snapshotInterval until it's closed.
+func (r *collabRoom) run() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		if err := r.snapshot(context.Background()); err != nil {
+			log.Printf("[error] failed to snapshot document: %v", err)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// receive applies the client's operation made at the revision and sends it on to everyone else.
+func (r *collabRoom) receive(c *collabClient, revision int, op ot.Operation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.check(c, revision); err != nil {
+		return err
+	}
+	var err error
+	for _, concurrent := range r.history[revision-r.base:] {
+		if op, _, err = ot.Transform(op, concurrent); err != nil {
+			return err
+		}
+	}
+	if err = r.apply(op); err != nil {
+		return err
+	}
+	c.queue(collabMessage{Type: "ack", Revision: r.revision()})
+	r.broadcast(c, collabMessage{Type: "op", Revision: r.revision(), Ops: op, ClientID: c.id})
+	r.trim()
+	return nil
+}
+
+// moveCursor sets where the client's cursor is, at the revision, and sends it on to everyone else.
+func (r *collabRoom) moveCursor(c *collabClient, revision int, cursor collabCursor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.check(c, revision); err != nil {
+		return err
+	}
+	for _, op := range r.history[revision-r.base:] {
+		cursor = transformCursor(cursor, op)
+	}
+	c.cursor = &cursor
+	r.broadcast(c, collabMessage{Type: "cursor", Revision: r.revision(), ClientID: c.id, Cursor: &cursor})
+	return nil
+}
+
+// revision returns the room's latest revision.
+func (r *collabRoom) revision() int {
+	return r.base + len(r.history)
+}
+
+// check returns an error unless the room has the operations made since the revision, and marks
+// the client as having it.
+func (r *collabRoom) check(c *collabClient, revision int) error {
+	if revision < 0 || revision > r.revision() {
+		return errInvalidRevision
+	}
+	if revision < r.base {
+		return errStaleRevision
+	}
+	c.revision = revision
+	return nil
+}
+
+// trim drops the operations made before the revisions all the clients are at, keeping at most
+// collabHistoryLimit of them.
+func (r *collabRoom) trim() {
+	base := r.revision()
+	for _, c := range r.clients {
+		if c.revision < base {
+			base = c.revision
+		}
+	}
+	if min := r.revision() - collabHistoryLimit; base < min {
+		base = min
+	}
+	if base > r.base {
+		r.history = r.history[base-r.base:]
+		r.base = base
+	}
+}
+
+// evict disconnects the clients that can't edit the document anymore.
+func (r *collabRoom) evict(forbidden func(c *collabClient) bool) {
+	for _, c := range r.clients {
+		if forbidden(c) && !c.closed {
+			c.queue(collabMessage{Type: "error", Revision: r.revision(), Error: errForbidden.Error()})
+			c.close()
+			log.Printf("[debug] evicted user %d from document: %d", c.user.ID, r.id)
+		}
+	}
+}
+
+// fail sends the client the error it caused.
+func (r *collabRoom) fail(c *collabClient, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.queue(collabMessage{Type: "error", Revision: r.revision(), Error: err.Error()})
+}
+
+// apply makes the operation to the room's text as its next revision.
+func (r *collabRoom) apply(op ot.Operation) error {
+	text, err := op.Apply(r.text)
+	if err != nil {
+		return err
+	}
+	r.text = text
+	r.history = append(r.history, op)
+	r.unsaved = append(r.unsaved, op)
+	for _, c := range r.clients {
+		if c.cursor != nil {
+			cursor := transformCursor(*c.cursor, op)
+			c.cursor = &cursor
+		}
+	}
+	return nil
+}
+
+// snapshot saves the room's text to its document if it's changed since it was last saved. If the
+// document was changed outside the room since, e.g. by the updateDocument mutation, the change is
+// merged in as an operation and the save is retried.
+func (r *collabRoom) snapshot(ctx context.Context) error {
+	for attempt := 1; len(r.unsaved) > 0; attempt++ {
+		text := r.text
+		d, err := r.documents.UpdateDocument(ctx, r.id, DocumentUpdate{ExpectedVersion: r.version, Text: &text})
+		var conflict *VersionConflictError
+		if errors.As(err, &conflict) && attempt < snapshotAttempts {
+			if err = r.merge(conflict.Current); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.saved, r.version, r.unsaved = text, d.Version, nil
+		r.publish(d)
+		log.Printf("[debug] snapshotted document: %d", r.id)
+	}
+	return nil
+}
+
+// merge applies the change made to the document outside the room and sends it to everyone.
+func (r *collabRoom) merge(current Document) error {
+	if current.Text == r.saved {
+		// e.g. only its title was changed.
+		r.version = current.Version
+		return nil
+	}
+	external := ot.Diff(r.saved, current.Text)
+	var err error
+	for i, op := range r.unsaved {
+		if external, r.unsaved[i], err = ot.Transform(external, op); err != nil {
+			return err
+		}
+	}
+	r.saved, r.version = current.Text, current.Version
+	// applying adds the change to the unsaved operations, which are now made to the current text.
+	unsaved := r.unsaved
+	if err = r.apply(external); err != nil {
+		return err
+	}
+	r.unsaved = unsaved
+	r.broadcast(nil, collabMessage{Type: "op", Revision: r.revision(), Ops: external})
+	return nil
+}
+
+// presence returns who's in the room.
+func (r *collabRoom) presence() []collabPresence {
+	presence := make([]collabPresence, 0, len(r.clients))
+	for _, c := range r.clients {
+		presence = append(presence, collabPresence{ClientID: c.id, UserID: c.user.ID, Email: c.user.Email, Cursor: c.cursor})
+	}
+	sort.Slice(presence, func(i, j int) bool { return presence[i].ClientID < presence[j].ClientID })
+	return presence
+}
+
+// broadcast sends the message to everyone in the room except the given client.
+func (r *collabRoom) broadcast(except *collabClient, m collabMessage) {
+	for _, c := range r.clients {
+		if c != except {
+			c.queue(m)
+		}
+	}
+}
+
+// transformCursor returns where the cursor is after the operation.
+func transformCursor(cursor collabCursor, op ot.Operation) collabCursor {
+	return collabCursor{
+		Position:     ot.TransformIndex(cursor.Position, op),
+		SelectionEnd: ot.TransformIndex(cursor.SelectionEnd, op),
+	}
+}
+
+// queue sends the message to the client unless it's too far behind, then it's disconnected. The
+// room's lock must be held.
+func (c *collabClient) queue(m collabMessage) {
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- m:
+	default:
+		log.Printf("[info] disconnecting slow client from document: user %d", c.user.ID)
+		c.close()
+	}
+}
+
+// close stops sending to the client, the write loop closes the connection once it's sent what's
+// queued. The room's lock must be held.
+func (c *collabClient) close() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// readLoop handles the client's messages until it disconnects or sends one that can't be applied.
+func (c *collabClient) readLoop(room *collabRoom) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				log.Printf("[error] failed to read message: %v", err)
+			}
+			return
+		}
+		var m collabMessage
+		switch {
+		case json.Unmarshal(b, &m) != nil:
+			err = errInvalidMessage
+		case m.Type == "op":
+			err = room.receive(c, m.Revision, m.Ops)
+		case m.Type == "cursor" && m.Cursor != nil:
+			err = room.moveCursor(c, m.Revision, *m.Cursor)
+		default:
+			err = errInvalidMessage
+		}
+		if err != nil {
+			room.fail(c, err)
+			return
+		}
+	}
+}
+
+// writeLoop sends the client its queued messages and pings until it's closed.
+func (c *collabClient) writeLoop() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case m, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.conn.WriteJSON(m); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
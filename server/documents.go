@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// documentTitle returns the document's title, or the first heading in its text if it wasn't
+// given one.
+func documentTitle(d Document) string {
+	if d.Title != "" {
+		return d.Title
+	}
+	return firstHeading(d.Text)
+}
+
+// documentSlug returns the document's slug, or one made from its title if it wasn't given one.
+func documentSlug(d Document) string {
+	if d.Slug != "" {
+		return d.Slug
+	}
+	return slugify(documentTitle(d))
+}
+
+// firstHeading returns the text of the first markdown heading, e.g. "# Title", in the text.
+func firstHeading(text string) string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		heading := strings.TrimLeft(line, "#")
+		// a heading needs a space after its #s, otherwise it's a tag or something.
+		if heading == "" || len(line)-len(heading) > 6 || !strings.HasPrefix(heading, " ") {
+			continue
+		}
+		if heading = strings.TrimSpace(strings.TrimRight(heading, "# ")); heading != "" {
+			return heading
+		}
+	}
+	return ""
+}
+
+// slugify returns s lowercased with runs of anything but letters and digits replaced by dashes.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// normalizeTags trims the tags and drops empty and duplicate ones.
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// Page sizes for document connections.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// documentConnectionResult is a page of a document listing, resolved by the DocumentConnection
+// type.
+type documentConnectionResult struct {
+	Edges    []documentEdge `json:"edges"`
+	PageInfo pageInfo       `json:"pageInfo"`
+}
+
+type documentEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   Document `json:"node"`
+}
+
+type pageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+// documentConnection returns the page of the author's documents matching the filter. It finds
+// one more document than the page has to know if there's a next page.
+func (s *Server) documentConnection(ctx context.Context, authorID int, filter DocumentFilter) (documentConnectionResult, error) {
+	var conn documentConnectionResult
+	limit := filter.Limit
+	filter.Limit++
+	documents, err := s.Documents.FindDocumentsByAuthor(ctx, authorID, filter)
+	if err != nil {
+		return conn, err
+	}
+	conn.PageInfo.HasPreviousPage = filter.After != nil
+	if len(documents) > limit {
+		conn.PageInfo.HasNextPage = true
+		documents = documents[:limit]
+	}
+	conn.Edges = make([]documentEdge, 0, len(documents))
+	for _, d := range documents {
+		conn.Edges = append(conn.Edges, documentEdge{Cursor: encodeCursor(documentCursor(d, filter.Sort)), Node: d})
+	}
+	if n := len(conn.Edges); n > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[n-1].Cursor
+	}
+	return conn, nil
+}
+
+// documentCursor returns the document's position in a listing sorted by sort.
+func documentCursor(d Document, sort DocumentSort) DocumentCursor {
+	c := DocumentCursor{ID: d.ID}
+	switch sort {
+	case SortUpdated:
+		c.Time = d.Updated
+	case SortTitle:
+		c.Title = strings.ToLower(documentTitle(d))
+	default:
+		c.Time = d.Created
+	}
+	return c
+}
+
+// encodeCursor returns the cursor as an opaque string for clients to page with.
+func encodeCursor(c DocumentCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the cursor encoded by encodeCursor.
+func decodeCursor(s string) (*DocumentCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c DocumentCursor
+	if err = json.Unmarshal(b, &c); err != nil || c.ID == 0 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
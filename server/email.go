@@ -0,0 +1,28 @@
+package server
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/travisjeffery/writegood/server/mailer"
+)
+
+// sendEmail renders the <name>_plain.html and <name>_html.html templates with data and sends
+// them to the email address.
+func (s *Server) sendEmail(ctx context.Context, to, subject, name string, data interface{}) error {
+	var plain, html bytes.Buffer
+	if err := s.templates.Lookup(name+"_plain.html").Execute(&plain, data); err != nil {
+		return err
+	}
+	if err := s.templates.Lookup(name+"_html.html").Execute(&html, data); err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, mailer.Message{
+		FromName:    s.Config.FromName,
+		FromAccount: s.Config.FromAccount,
+		To:          to,
+		Subject:     subject,
+		Plain:       plain.String(),
+		HTML:        html.String(),
+	})
+}
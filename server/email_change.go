@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// emailChangeAudience marks tokens that confirm an email change so they can't be used for anything
+// else.
+const emailChangeAudience = "email_change"
+
+// EmailChangeClaims are the claims of the token emailed to the new address to confirm an email
+// change. The token's only good while the user still has the old email, so it can be used once.
+type EmailChangeClaims struct {
+	UserID   int
+	OldEmail string
+	NewEmail string
+	jwt.StandardClaims
+}
+
+// requestEmailChange emails a confirmation link to the new email and a notice to the user's current
+// email. The email isn't changed until the link's opened.
+func (s *Server) requestEmailChange(ctx context.Context, user User, newEmail string) error {
+	newEmail = normalizeEmail(newEmail)
+	addr, err := mail.ParseAddress(newEmail)
+	if err != nil || addr.Address != newEmail {
+		return errInvalidEmail
+	}
+	_, err = s.Users.FindUserByEmail(ctx, newEmail)
+	if err == nil {
+		return errEmailTaken
+	}
+	if err != ErrNotFound {
+		return err
+	}
+
+	now := time.Now()
+	signedToken, err := s.signToken(EmailChangeClaims{
+		UserID:   user.ID,
+		OldEmail: user.Email,
+		NewEmail: newEmail,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  emailChangeAudience,
+			Issuer:    "Write Good",
+			Id:        uuid.NewV4().String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.Config.SignInExpire).Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Token    string
+		Domain   string
+		OldEmail string
+		NewEmail string
+	}{
+		Token:    signedToken,
+		Domain:   s.Config.Domain,
+		OldEmail: user.Email,
+		NewEmail: newEmail,
+	}
+	if err = s.sendEmail(ctx, newEmail, "Confirm your new Write Good email", "email_change", data); err != nil {
+		return err
+	}
+	if err = s.sendEmail(ctx, user.Email, "Your Write Good email is being changed", "email_change_notice", data); err != nil {
+		return err
+	}
+	log.Printf("[debug] queued email change emails for user: %d", user.ID)
+	return nil
+}
+
+// HandleEmailChangeVerify changes the user's email from an email change confirmation link.
+func (s *Server) HandleEmailChangeVerify(w http.ResponseWriter, r *http.Request) {
+	var claims EmailChangeClaims
+	if err := s.parseToken(r.URL.Query().Get("token"), &claims); err != nil {
+		log.Printf("[error] failed to parse email change token: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !claims.VerifyAudience(emailChangeAudience, true) {
+		log.Printf("[error] failed to verify email change claims for user: %d", claims.UserID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Users.UpdateUserEmail(r.Context(), claims.UserID, claims.OldEmail, claims.NewEmail)
+	if err == ErrNotFound || err == errEmailTaken {
+		// the link was used already or the new email was taken since it was sent.
+		log.Printf("[error] failed to change email of user: %d", claims.UserID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to update user email: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[debug] changed email of user: %d", user.ID)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
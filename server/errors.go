@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+)
+
+// graphqlError is an error with a code GraphQL clients can check in the error's extensions instead
+// of matching its message.
+type graphqlError struct {
+	code    string
+	message string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// VersionConflictError is returned when a document is updated from a version that isn't its current
+// one, e.g. because it was changed in another tab. The extensions have the current version and
+// text so clients can merge their changes and try again.
+type VersionConflictError struct {
+	Current Document
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("document was changed, it's at version %d", e.Current.Version)
+}
+
+func (e *VersionConflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":            "VERSION_CONFLICT",
+		"current_version": e.Current.Version,
+		"current_text":    e.Current.Text,
+	}
+}
+
+// versionConflict returns the error for an update to the document that didn't change a row because
+// its version didn't match, or ErrNotFound if the document doesn't exist.
+func versionConflict(current Document, err error) error {
+	if err != nil {
+		return err
+	}
+	return &VersionConflictError{Current: current}
+}
+
+// errNeedsPostgres is returned by features that are only stored in Postgres when running with
+// another store, e.g. SQLite.
+var errNeedsPostgres = errors.New("needs a postgres database")
+
+var (
+	errInvalidEmail   = &graphqlError{code: "INVALID_EMAIL", message: "invalid email"}
+	errEmailTaken     = &graphqlError{code: "EMAIL_TAKEN", message: "email is already in use"}
+	errInvalidCursor  = &graphqlError{code: "INVALID_CURSOR", message: "invalid cursor"}
+	errInvalidVersion = &graphqlError{code: "INVALID_VERSION", message: "versions start at 1"}
+	errInvalidTime    = &graphqlError{code: "INVALID_TIME", message: "times must be RFC 3339, e.g. 2006-01-02T15:04:05Z"}
+)
+
+// uniqueViolation is Postgres' error code for unique constraint violations.
+const uniqueViolation = "23505"
+
+// foreignKeyViolation is Postgres' error code for foreign key constraint violations.
+const foreignKeyViolation = "23503"
+
+// isUniqueViolation returns whether the err is from violating a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// isForeignKeyViolation returns whether the err is from referencing a row that doesn't exist.
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"strings"
+)
+
+var (
+	errInvalidFolderName = &graphqlError{code: "INVALID_FOLDER_NAME", message: "folder names can't be empty"}
+	errFolderCycle       = &graphqlError{code: "FOLDER_CYCLE", message: "a folder can't be moved into itself"}
+)
+
+// findOwnFolder returns the owner's folder. Other users' folders aren't found so their ids aren't
+// leaked.
+func (s *Server) findOwnFolder(ctx context.Context, ownerID, id int) (Folder, error) {
+	f, err := s.Folders.FindFolderByID(ctx, id)
+	if err != nil {
+		return f, err
+	}
+	if f.OwnerID != ownerID {
+		return Folder{}, ErrNotFound
+	}
+	return f, nil
+}
+
+// createFolder creates a folder for the owner in the parent, or at the top if parentID is nil.
+func (s *Server) createFolder(ctx context.Context, ownerID int, name string, parentID *int) (Folder, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Folder{}, errInvalidFolderName
+	}
+	if parentID != nil {
+		if _, err := s.findOwnFolder(ctx, ownerID, *parentID); err != nil {
+			return Folder{}, err
+		}
+	}
+	return s.Folders.CreateFolder(ctx, Folder{OwnerID: ownerID, ParentID: parentID, Name: name})
+}
+
+// renameFolder renames the owner's folder.
+func (s *Server) renameFolder(ctx context.Context, ownerID, id int, name string) (Folder, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Folder{}, errInvalidFolderName
+	}
+	if _, err := s.findOwnFolder(ctx, ownerID, id); err != nil {
+		return Folder{}, err
+	}
+	return s.Folders.RenameFolder(ctx, id, name)
+}
+
+// moveFolder moves the owner's folder into the parent, or to the top if parentID is nil. It
+// returns errFolderCycle if the parent is the folder or in it.
+func (s *Server) moveFolder(ctx context.Context, ownerID, id int, parentID *int) (Folder, error) {
+	if _, err := s.findOwnFolder(ctx, ownerID, id); err != nil {
+		return Folder{}, err
+	}
+	for ancestor := parentID; ancestor != nil; {
+		if *ancestor == id {
+			return Folder{}, errFolderCycle
+		}
+		f, err := s.findOwnFolder(ctx, ownerID, *ancestor)
+		if err != nil {
+			return Folder{}, err
+		}
+		ancestor = f.ParentID
+	}
+	return s.Folders.MoveFolder(ctx, id, parentID)
+}
+
+// deleteFolder deletes the owner's folder, its folders and documents are moved into its parent.
+func (s *Server) deleteFolder(ctx context.Context, ownerID, id int) error {
+	if _, err := s.findOwnFolder(ctx, ownerID, id); err != nil {
+		return err
+	}
+	return s.Folders.DeleteFolder(ctx, id)
+}
+
+// moveDocument moves the author's document into their folder, or out of any folder if folderID is
+// nil.
+func (s *Server) moveDocument(ctx context.Context, authorID, id int, folderID *int) (Document, error) {
+	d, err := s.Documents.FindDocumentByID(ctx, id)
+	if err != nil {
+		return d, err
+	}
+	if d.AuthorID != authorID {
+		return Document{}, ErrNotFound
+	}
+	if folderID != nil {
+		if _, err = s.findOwnFolder(ctx, authorID, *folderID); err != nil {
+			return Document{}, err
+		}
+	}
+	return s.Documents.MoveDocument(ctx, id, folderID)
+}
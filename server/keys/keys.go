@@ -0,0 +1,254 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/securecookie"
+)
+
+// A keys dir is laid out as:
+//
+//	current            the kid of the signing key new tokens are signed with
+//	signing/<kid>.pem  the RSA private keys, old ones verify tokens signed before a rotation
+//	session_keys       the session cookie hash and block key pairs, one per line, newest first
+//	hash_salt          the salt for sign in hashes
+const (
+	currentFile     = "current"
+	signingDir      = "signing"
+	sessionKeysFile = "session_keys"
+	hashSaltFile    = "hash_salt"
+)
+
+// rsaBits is the size of generated signing keys.
+const rsaBits = 2048
+
+// Keyring holds the keys used to sign and verify tokens and to encode session cookies.
+type Keyring struct {
+	// Current is the kid of the key used to sign new tokens.
+	Current     string
+	SigningKeys map[string]*rsa.PrivateKey
+	VerifyKeys  map[string]*rsa.PublicKey
+	// SessionKeys are hash and block key pairs for securecookie.CodecsFromPairs, newest first.
+	SessionKeys [][]byte
+}
+
+// SigningKey returns the current signing key and its kid.
+func (k *Keyring) SigningKey() (string, *rsa.PrivateKey) {
+	return k.Current, k.SigningKeys[k.Current]
+}
+
+// VerifyKey returns the key to verify tokens signed with the kid. Tokens without a kid were signed
+// before we had kids so they're verified with the current key.
+func (k *Keyring) VerifyKey(kid string) (*rsa.PublicKey, error) {
+	if kid == "" {
+		kid = k.Current
+	}
+	key, ok := k.VerifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	return key, nil
+}
+
+// Load reads the keyring from the keys dir.
+func Load(dir string) (*Keyring, error) {
+	current, err := ioutil.ReadFile(filepath.Join(dir, currentFile))
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		Current:     strings.TrimSpace(string(current)),
+		SigningKeys: make(map[string]*rsa.PrivateKey),
+		VerifyKeys:  make(map[string]*rsa.PublicKey),
+	}
+
+	kids, err := signingKIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, kid := range kids {
+		b, err := ioutil.ReadFile(filepath.Join(dir, signingDir, kid+".pem"))
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key: %s: %v", kid, err)
+		}
+		k.SigningKeys[kid] = key
+		k.VerifyKeys[kid] = &key.PublicKey
+	}
+	if _, ok := k.SigningKeys[k.Current]; !ok {
+		return nil, fmt.Errorf("current signing key: %s not found", k.Current)
+	}
+
+	k.SessionKeys, err = readSessionKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.SessionKeys) == 0 {
+		return nil, fmt.Errorf("no session keys in: %s", dir)
+	}
+	return k, nil
+}
+
+// Rotate adds a new signing key and session key pair to the keys dir, creating it if needed, and
+// makes them current. The previous keep keys of each are kept so tokens and sessions made with
+// them still work, older ones are deleted. It returns the new key's kid.
+func Rotate(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, signingDir), 0700); err != nil {
+		return "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		return "", err
+	}
+	// kids are timestamps so they sort oldest first.
+	kid := time.Now().UTC().Format("20060102T150405.000000000Z")
+	pemBytes := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if err = ioutil.WriteFile(filepath.Join(dir, signingDir, kid+".pem"), pemBytes, 0600); err != nil {
+		return "", err
+	}
+
+	sessionKeys, err := readSessionKeys(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	sessionKeys = append([][]byte{
+		securecookie.GenerateRandomKey(64),
+		securecookie.GenerateRandomKey(32),
+	}, sessionKeys...)
+	if max := 2 * (keep + 1); len(sessionKeys) > max {
+		sessionKeys = sessionKeys[:max]
+	}
+	if err = writeSessionKeys(dir, sessionKeys); err != nil {
+		return "", err
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, currentFile), []byte(kid+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	kids, err := signingKIDs(dir)
+	if err != nil {
+		return "", err
+	}
+	for len(kids) > keep+1 {
+		if err = os.Remove(filepath.Join(dir, signingDir, kids[0]+".pem")); err != nil {
+			return "", err
+		}
+		kids = kids[1:]
+	}
+	return kid, nil
+}
+
+func signingKIDs(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(dir, signingDir))
+	if err != nil {
+		return nil, err
+	}
+	var kids []string
+	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".pem" {
+			kids = append(kids, strings.TrimSuffix(f.Name(), ".pem"))
+		}
+	}
+	sort.Strings(kids)
+	return kids, nil
+}
+
+func readSessionKeys(dir string) ([][]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, sessionKeysFile))
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("session keys must be hash and block key pairs")
+		}
+		for _, f := range fields {
+			key, err := base64.StdEncoding.DecodeString(f)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func writeSessionKeys(dir string, keys [][]byte) error {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(keys); i += 2 {
+		fmt.Fprintf(&buf, "%s %s\n", base64.StdEncoding.EncodeToString(keys[i]), base64.StdEncoding.EncodeToString(keys[i+1]))
+	}
+	return ioutil.WriteFile(filepath.Join(dir, sessionKeysFile), buf.Bytes(), 0600)
+}
+
+// FromPEM returns a keyring with the single sign and verify key pair. Tokens are signed without a
+// kid and, as before keys dirs, the sign key's PEM is the session cookie hash key.
+func FromPEM(signPEM, verifyPEM []byte) (*Keyring, error) {
+	signKey, err := jwt.ParseRSAPrivateKeyFromPEM(signPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sign key: %v", err)
+	}
+	verifyKey, err := jwt.ParseRSAPublicKeyFromPEM(verifyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse verify key: %v", err)
+	}
+	return &Keyring{
+		SigningKeys: map[string]*rsa.PrivateKey{"": signKey},
+		VerifyKeys:  map[string]*rsa.PublicKey{"": verifyKey},
+		SessionKeys: [][]byte{signPEM},
+	}, nil
+}
+
+// Generate creates a keys dir with a signing key, session keys and hash salt for a new deployment.
+// It fails if the dir already has keys, use Rotate to replace them.
+func Generate(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, currentFile)); err == nil {
+		return "", fmt.Errorf("keys already exist in: %s", dir)
+	}
+	kid, err := Rotate(dir, 0)
+	if err != nil {
+		return "", err
+	}
+	salt := fmt.Sprintf("%x\n", securecookie.GenerateRandomKey(32))
+	if err = ioutil.WriteFile(filepath.Join(dir, hashSaltFile), []byte(salt), 0600); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// LoadHashSalt reads the hash salt from the keys dir.
+func LoadHashSalt(dir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, hashSaltFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,81 @@
+package keys_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/keys"
+)
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := keys.Rotate(dir, 1)
+	require.NoError(t, err)
+
+	k, err := keys.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, first, k.Current)
+	require.Len(t, k.SessionKeys, 2)
+	kid, key := k.SigningKey()
+	require.Equal(t, first, kid)
+	verify, err := k.VerifyKey(first)
+	require.NoError(t, err)
+	require.Equal(t, &key.PublicKey, verify)
+	firstSessionKeys := k.SessionKeys
+
+	second, err := keys.Rotate(dir, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	k, err = keys.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, second, k.Current)
+	// the old keys are kept so existing tokens and sessions work
+	_, err = k.VerifyKey(first)
+	require.NoError(t, err)
+	require.Len(t, k.SessionKeys, 4)
+	require.Equal(t, firstSessionKeys, k.SessionKeys[2:])
+
+	third, err := keys.Rotate(dir, 1)
+	require.NoError(t, err)
+
+	k, err = keys.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, third, k.Current)
+	// only keep one previous key
+	_, err = k.VerifyKey(first)
+	require.Error(t, err)
+	_, err = k.VerifyKey(second)
+	require.NoError(t, err)
+	require.Len(t, k.SessionKeys, 4)
+	files, err := ioutil.ReadDir(filepath.Join(dir, "signing"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	kid, err := keys.Generate(dir)
+	require.NoError(t, err)
+
+	k, err := keys.Load(dir)
+	require.NoError(t, err)
+	require.Equal(t, kid, k.Current)
+
+	salt, err := keys.LoadHashSalt(dir)
+	require.NoError(t, err)
+	require.Len(t, salt, 64)
+
+	// don't clobber existing keys
+	_, err = keys.Generate(dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/travisjeffery/writegood/server/login"
+)
+
+// UserIdentity is an identity with a login provider that's linked to a user so they can sign in
+// with it.
+type UserIdentity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   int       `json:"user_id"`
+	Email    *string   `json:"email"`
+	Created  time.Time `json:"created"`
+}
+
+// loginSession holds the state of a sign in with a login provider between the redirects.
+const loginSession = "login_session"
+
+// HandleLogin redirects the user to the login provider to sign in.
+func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.loginProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var values [3]string
+	for i := range values {
+		v, err := login.RandomString()
+		if err != nil {
+			log.Printf("[error] failed to generate login state: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := provider.AuthURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("[error] failed to get login provider auth url: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	session, err := s.sessions.Get(r, loginSession)
+	if err != nil {
+		log.Printf("[debug] replacing bad login session: %v", err)
+	}
+	session.Values["provider"] = provider.Name()
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	session.Options.MaxAge = int((10 * time.Minute).Seconds())
+	if err = s.sessions.Save(r, w, session); err != nil {
+		log.Printf("[error] failed to save session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleLoginCallback finishes signing in with the login provider and signs the user in to the
+// account linked to their identity.
+func (s *Server) HandleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.loginProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	session, err := s.sessions.Get(r, loginSession)
+	if err != nil {
+		log.Printf("[error] failed to get login session: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	name, _ := session.Values["provider"].(string)
+
+	// the login session is only good for one callback.
+	session.Options.MaxAge = -1
+	if err = s.sessions.Save(r, w, session); err != nil {
+		log.Printf("[error] failed to save session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		log.Printf("[error] login provider: %s responded with error: %s: %s", provider.Name(), errCode, q.Get("error_description"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if state == "" || q.Get("state") != state || name != provider.Name() {
+		log.Printf("[error] login state mismatch for provider: %s", provider.Name())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), q.Get("code"), nonce, verifier)
+	if err != nil {
+		log.Printf("[error] failed to exchange login code: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userForIdentity(r.Context(), identity)
+	if err == errUnlinkedIdentity {
+		log.Printf("[info] no account for identity: %s/%s", identity.Provider, identity.Subject)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to find user for identity: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	signedIn, err := s.Users.UpdateUserSignedIn(r.Context(), user.ID, time.Now())
+	if err != nil {
+		log.Printf("[error] failed to update user signed in: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user.SignedIn = &signedIn
+	if err = s.startSession(w, r, &user); err != nil {
+		log.Printf("[error] failed to start session: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	log.Printf("[debug] signed in user: %d with provider: %s", user.ID, identity.Provider)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var errUnlinkedIdentity = errors.New("identity isn't linked to a user")
+
+// userForIdentity returns the user linked to the identity. Identities that aren't linked yet are
+// linked to the user with the same email if the provider verified it, or to a new user if they can
+// sign up.
+func (s *Server) userForIdentity(ctx context.Context, identity login.Identity) (User, error) {
+	user, err := s.Credentials.FindUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != ErrNotFound {
+		return user, err
+	}
+	if !identity.EmailVerified || identity.Email == "" {
+		return user, errUnlinkedIdentity
+	}
+	user, err = s.Users.FindUserByEmail(ctx, identity.Email)
+	if err == ErrNotFound {
+		if !s.canSignUp(identity.Email) {
+			return user, errUnlinkedIdentity
+		}
+		user, err = s.Users.CreateUser(ctx, identity.Email)
+		if err != nil {
+			return user, err
+		}
+	} else if err != nil {
+		return user, err
+	}
+	if err = s.Credentials.LinkIdentity(ctx, user.ID, identity); err != nil {
+		return user, err
+	}
+	log.Printf("[debug] linked identity: %s/%s to user: %d", identity.Provider, identity.Subject, user.ID)
+	return user, nil
+}
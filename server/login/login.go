@@ -0,0 +1,43 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Identity is who a login provider verified the user to be.
+type Identity struct {
+	Provider string
+	Subject  string
+	// Email is lowercased and trimmed like the emails users sign up with.
+	Email         string
+	EmailVerified bool
+}
+
+// Provider is a way to sign in other than the magic link emails, e.g. a company's SSO.
+type Provider interface {
+	// Name identifies the provider in urls and linked identities.
+	Name() string
+	// AuthURL returns the url to send the user to so they can sign in with the provider. The state,
+	// nonce and verifier must be kept for the matching call to Exchange.
+	AuthURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	// Exchange trades the code the provider redirected back with for the user's identity.
+	Exchange(ctx context.Context, code, nonce, verifier string) (Identity, error)
+}
+
+// RandomString returns a random url safe string, used for states, nonces and PKCE verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for the verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,324 @@
+package login
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// OIDC is an OpenID Connect provider using the authorization code flow with PKCE. The provider's
+// endpoints and keys are discovered from the Issuer.
+type OIDC struct {
+	ProviderName string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested in addition to openid, defaults to email.
+	Scopes []string
+	// Client is used to call the provider, defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Name of the provider.
+func (o *OIDC) Name() string {
+	return o.ProviderName
+}
+
+// AuthURL returns the provider's authorization url with the PKCE challenge for the verifier.
+func (o *OIDC) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email"}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.ClientID},
+		"redirect_uri":          {o.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems the code at the token endpoint and returns the identity from the validated ID
+// token.
+func (o *OIDC) Exchange(ctx context.Context, code, nonce, verifier string) (Identity, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.RedirectURL},
+		"client_id":     {o.ClientID},
+		"code_verifier": {verifier},
+	}
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := o.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return Identity{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("token endpoint responded with status: %d", res.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return Identity{}, err
+	}
+	if tokens.IDToken == "" {
+		return Identity{}, errors.New("token response is missing id_token")
+	}
+	claims, err := o.verify(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Provider:      o.ProviderName,
+		Subject:       claims.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+// idTokenClaims are the ID token claims we validate and use.
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	ExpiresAt       int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   booleanClaim `json:"email_verified"`
+}
+
+// leeway allows for clock skew between us and the provider.
+const leeway = time.Minute
+
+func (c *idTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("id token is expired")
+	}
+	if c.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("id token used before issued")
+	}
+	return nil
+}
+
+// verify validates the ID token's signature against the provider's keys and its claims against
+// our config and the nonce we sent.
+func (o *OIDC) verify(ctx context.Context, idToken, nonce string) (*idTokenClaims, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var claims idTokenClaims
+	parser := &jwt.Parser{ValidMethods: []string{"RS256"}}
+	_, err = parser.ParseWithClaims(idToken, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(ctx, d, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != d.Issuer {
+		return nil, fmt.Errorf("id token issuer mismatch: %s", claims.Issuer)
+	}
+	if !claims.Audience.contains(o.ClientID) {
+		return nil, errors.New("id token audience mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != o.ClientID {
+		return nil, errors.New("id token authorized party mismatch")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token is missing subject")
+	}
+	return &claims, nil
+}
+
+// discover fetches and caches the provider's configuration.
+func (o *OIDC) discover(ctx context.Context) (*discovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+	var d discovery
+	if err := o.getJSON(ctx, strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != o.Issuer {
+		return nil, fmt.Errorf("discovered issuer: %s doesn't match configured issuer: %s", d.Issuer, o.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	o.discovery = &d
+	return o.discovery, nil
+}
+
+// key returns the provider's signing key with the kid, refetching the keys once if it's unknown
+// since the provider may have rotated them.
+func (o *OIDC) key(ctx context.Context, d *discovery, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	o.keys = keys
+	k, ok := o.keys[kid]
+	if !ok {
+		// a single key may be used without a kid.
+		if kid == "" && len(o.keys) == 1 {
+			for _, k := range o.keys {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return k, nil
+}
+
+func (o *OIDC) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := o.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status: %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// audience is the aud claim, which may be a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// booleanClaim is a bool claim some providers send as a string.
+type booleanClaim bool
+
+func (b *booleanClaim) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = booleanClaim(t)
+	case string:
+		*b = booleanClaim(t == "true")
+	}
+	return nil
+}
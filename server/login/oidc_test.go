@@ -0,0 +1,164 @@
+package login_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/login"
+)
+
+// issuer is a stand-in OpenID Connect provider.
+type issuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newIssuer(t *testing.T) *issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	iss := &issuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 iss.URL,
+			"authorization_endpoint": iss.URL + "/authorize",
+			"token_endpoint":         iss.URL + "/token",
+			"jwks_uri":               iss.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("code") != "the-code" || login.CodeChallenge(r.Form.Get("code_verifier")) != iss.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, iss.claims)
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	iss.Server = httptest.NewServer(mux)
+	return iss
+}
+
+func TestOIDC(t *testing.T) {
+	ctx := context.Background()
+	iss := newIssuer(t)
+	defer iss.Close()
+
+	provider := &login.OIDC{
+		ProviderName: "sso",
+		Issuer:       iss.URL,
+		ClientID:     "writegood",
+		RedirectURL:  "http://localhost:8080/login/sso/callback",
+	}
+
+	signIn := func(t *testing.T, claims jwt.MapClaims, nonce string) (login.Identity, error) {
+		verifier, err := login.RandomString()
+		require.NoError(t, err)
+		authURL, err := provider.AuthURL(ctx, "the-state", nonce, verifier)
+		require.NoError(t, err)
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		require.Equal(t, "/authorize", u.Path)
+		require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+		require.Equal(t, "the-state", u.Query().Get("state"))
+		iss.challenge = u.Query().Get("code_challenge")
+		iss.claims = claims
+		return provider.Exchange(ctx, "the-code", nonce, verifier)
+	}
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            iss.URL,
+			"sub":            "user-1",
+			"aud":            "writegood",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          "the-nonce",
+			"email":          "callie@example.com",
+			"email_verified": true,
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		identity, err := signIn(t, claims(), "the-nonce")
+		require.NoError(t, err)
+		require.Equal(t, login.Identity{
+			Provider:      "sso",
+			Subject:       "user-1",
+			Email:         "callie@example.com",
+			EmailVerified: true,
+		}, identity)
+	})
+
+	t.Run("email is normalized", func(t *testing.T) {
+		c := claims()
+		c["email"] = " Callie@Example.COM "
+		identity, err := signIn(t, c, "the-nonce")
+		require.NoError(t, err)
+		require.Equal(t, "callie@example.com", identity.Email)
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		_, err := signIn(t, claims(), "other-nonce")
+		require.Error(t, err)
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		c := claims()
+		c["aud"] = []string{"someone-else"}
+		_, err := signIn(t, c, "the-nonce")
+		require.Error(t, err)
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		c := claims()
+		c["iss"] = "https://evil.example.com"
+		_, err := signIn(t, c, "the-nonce")
+		require.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		c := claims()
+		c["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err := signIn(t, c, "the-nonce")
+		require.Error(t, err)
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		authURL, err := provider.AuthURL(ctx, "the-state", "the-nonce", "the-verifier")
+		require.NoError(t, err)
+		u, err := url.Parse(authURL)
+		require.NoError(t, err)
+		iss.challenge = u.Query().Get("code_challenge")
+		iss.claims = claims()
+		_, err = provider.Exchange(ctx, "the-code", "the-nonce", "other-verifier")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File writes emails as .eml files to Dir, useful for local development and tests.
+type File struct {
+	Dir string
+}
+
+// Send writes the message to a new .eml file in the directory.
+func (f *File) Send(ctx context.Context, m Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.Replace(m.To, "/", "_", -1))
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), msg, 0644)
+}
+
+// Log writes emails to Out, or stdout if Out is nil.
+type Log struct {
+	Out io.Writer
+}
+
+// Send writes the message to the writer.
+func (l *Log) Send(ctx context.Context, m Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	out := l.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	_, err = fmt.Fprintf(out, "%s\n", msg)
+	return err
+}
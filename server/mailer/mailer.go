@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"time"
+)
+
+// Message is an email with both a plain text and html body.
+type Message struct {
+	FromName    string
+	FromAccount string
+	To          string
+	Subject     string
+	Plain       string
+	HTML        string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Bytes returns the message encoded as a multipart/alternative MIME email.
+func (m Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	from := mail.Address{Name: m.FromName, Address: m.FromAccount}
+	to := mail.Address{Address: m.To}
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.Plain},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, p := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = pw.Write([]byte(p.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,58 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/mailer"
+)
+
+var msg = mailer.Message{
+	FromName:    "Write Good",
+	FromAccount: "tj@writegood.app",
+	To:          "callie@example.com",
+	Subject:     "Sign in to Write Good",
+	Plain:       "plain body",
+	HTML:        "<p>html body</p>",
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &mailer.File{Dir: filepath.Join(dir, "outbox")}
+	require.NoError(t, m.Send(context.Background(), msg))
+
+	files, err := ioutil.ReadDir(m.Dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, ".eml", filepath.Ext(files[0].Name()))
+
+	f, err := os.Open(filepath.Join(m.Dir, files[0].Name()))
+	require.NoError(t, err)
+	defer f.Close()
+	parsed, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+	require.Equal(t, "<callie@example.com>", parsed.Header.Get("To"))
+	require.Equal(t, msg.Subject, parsed.Header.Get("Subject"))
+
+	body, err := ioutil.ReadAll(parsed.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), msg.Plain)
+	require.Contains(t, string(body), msg.HTML)
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &mailer.Log{Out: &buf}
+	require.NoError(t, m.Send(context.Background(), msg))
+	require.Contains(t, buf.String(), "Subject: Sign in to Write Good")
+	require.Contains(t, buf.String(), msg.Plain)
+}
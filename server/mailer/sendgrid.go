@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// SendGrid sends emails with the SendGrid API.
+type SendGrid struct {
+	Client *sendgrid.Client
+}
+
+// Send the message with SendGrid.
+func (s *SendGrid) Send(ctx context.Context, m Message) error {
+	email := mail.NewSingleEmail(
+		mail.NewEmail(m.FromName, m.FromAccount),
+		m.Subject,
+		mail.NewEmail("", m.To),
+		m.Plain,
+		m.HTML,
+	)
+	res, err := s.Client.Send(email)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid responded with status: %d: %s", res.StatusCode, res.Body)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+)
+
+// SMTP sends emails through an SMTP server. Auth is only used if Username is set.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+// Send the message through the SMTP server.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, m.FromAccount, []string{m.To}, msg)
+}
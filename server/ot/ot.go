@@ -0,0 +1,314 @@
+// Package ot implements operational transformation for plain text, so concurrent edits to a
+// document made from the same version can be applied in any order and converge.
+//
+// Operations use ot.js's JSON format: an array of components where a positive number retains that
+// many characters, a negative number deletes that many and a string inserts itself. Lengths and
+// positions count Unicode code points.
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	// ErrBaseLength is returned when an operation isn't for a text of the length it's applied to.
+	ErrBaseLength = errors.New("ot: operation's base length doesn't match the text")
+	errTransform  = errors.New("ot: operations to transform must have the same base length")
+	errTooLong    = errors.New("ot: operation's length overflows")
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+// Op is one component of an Operation, it either retains, inserts or deletes.
+type Op struct {
+	Retain int
+	Insert string
+	Delete int
+}
+
+func (o Op) isRetain() bool { return o.Retain > 0 }
+func (o Op) isInsert() bool { return o.Insert != "" }
+func (o Op) isDelete() bool { return o.Delete > 0 }
+
+// Operation is an edit that walks over a text from its start, retaining, inserting and deleting as
+// it goes. It must walk over the whole text, so its base length is the text's length.
+type Operation []Op
+
+// Retain returns the operation retaining n more characters.
+func (o Operation) Retain(n int) Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].isRetain() {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, Op{Retain: n})
+}
+
+// Insert returns the operation inserting s next. Inserts go before deletes at the same position so
+// equal operations have the same components.
+func (o Operation) Insert(s string) Operation {
+	if s == "" {
+		return o
+	}
+	last := len(o) - 1
+	if last >= 0 && o[last].isInsert() {
+		o[last].Insert += s
+		return o
+	}
+	if last >= 0 && o[last].isDelete() {
+		if last > 0 && o[last-1].isInsert() {
+			o[last-1].Insert += s
+			return o
+		}
+		o = append(o, o[last])
+		o[last] = Op{Insert: s}
+		return o
+	}
+	return append(o, Op{Insert: s})
+}
+
+// Delete returns the operation deleting n more characters.
+func (o Operation) Delete(n int) Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].isDelete() {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, Op{Delete: n})
+}
+
+// BaseLen returns the length of the texts the operation applies to, or -1 if it overflows so it
+// doesn't apply to any.
+func (o Operation) BaseLen() int {
+	n := 0
+	for _, op := range o {
+		for _, count := range []int{op.Retain, op.Delete} {
+			if count < 0 || count > maxInt-n {
+				return -1
+			}
+			n += count
+		}
+	}
+	return n
+}
+
+// TargetLen returns the length of the texts the operation makes.
+func (o Operation) TargetLen() int {
+	n := 0
+	for _, op := range o {
+		n += op.Retain + utf8.RuneCountInString(op.Insert)
+	}
+	return n
+}
+
+// Apply returns the text with the operation applied.
+func (o Operation) Apply(text string) (string, error) {
+	runes := []rune(text)
+	if len(runes) != o.BaseLen() {
+		return "", ErrBaseLength
+	}
+	out := make([]rune, 0, o.TargetLen())
+	pos := 0
+	for _, op := range o {
+		// the base length matched so these only fail if the operation's components are malformed.
+		switch {
+		case op.isRetain():
+			if op.Retain > len(runes)-pos {
+				return "", ErrBaseLength
+			}
+			out = append(out, runes[pos:pos+op.Retain]...)
+			pos += op.Retain
+		case op.isInsert():
+			out = append(out, []rune(op.Insert)...)
+		case op.isDelete():
+			if op.Delete > len(runes)-pos {
+				return "", ErrBaseLength
+			}
+			pos += op.Delete
+		}
+	}
+	return string(out), nil
+}
+
+// Transform returns a' and b' for operations a and b made concurrently to the same text, such that
+// applying a then b' makes the same text as applying b then a'. When both insert at the same
+// position a's insert goes first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if a.BaseLen() < 0 || a.BaseLen() != b.BaseLen() {
+		return nil, nil, errTransform
+	}
+	var a1, b1 Operation
+	i, j := 0, 0
+	var op1, op2 *Op
+	next := func(o Operation, k *int) *Op {
+		if *k >= len(o) {
+			return nil
+		}
+		op := o[*k]
+		*k++
+		return &op
+	}
+	op1, op2 = next(a, &i), next(b, &j)
+	for op1 != nil || op2 != nil {
+		if op1 != nil && op1.isInsert() {
+			a1 = a1.Insert(op1.Insert)
+			b1 = b1.Retain(utf8.RuneCountInString(op1.Insert))
+			op1 = next(a, &i)
+			continue
+		}
+		if op2 != nil && op2.isInsert() {
+			a1 = a1.Retain(utf8.RuneCountInString(op2.Insert))
+			b1 = b1.Insert(op2.Insert)
+			op2 = next(b, &j)
+			continue
+		}
+		if op1 == nil || op2 == nil {
+			return nil, nil, errTransform
+		}
+		n1, n2 := op1.Retain+op1.Delete, op2.Retain+op2.Delete
+		n := n1
+		if n2 < n {
+			n = n2
+		}
+		switch {
+		case op1.isRetain() && op2.isRetain():
+			a1 = a1.Retain(n)
+			b1 = b1.Retain(n)
+		case op1.isDelete() && op2.isRetain():
+			a1 = a1.Delete(n)
+		case op1.isRetain() && op2.isDelete():
+			b1 = b1.Delete(n)
+		}
+		// both deleting the same characters leaves nothing for either to do.
+		op1 = consume(op1, n)
+		op2 = consume(op2, n)
+		if op1 == nil {
+			op1 = next(a, &i)
+		}
+		if op2 == nil {
+			op2 = next(b, &j)
+		}
+	}
+	return a1, b1, nil
+}
+
+// consume returns what's left of the retain or delete after n characters, or nil if it's done.
+func consume(op *Op, n int) *Op {
+	if op.isRetain() {
+		op.Retain -= n
+		if op.Retain == 0 {
+			return nil
+		}
+		return op
+	}
+	op.Delete -= n
+	if op.Delete == 0 {
+		return nil
+	}
+	return op
+}
+
+// TransformIndex returns where a position in the text, e.g. a cursor, is after the operation.
+// Inserts at the position push it along.
+func TransformIndex(index int, o Operation) int {
+	newIndex, pos := index, 0
+	for _, op := range o {
+		if pos > index {
+			break
+		}
+		switch {
+		case op.isRetain():
+			pos += op.Retain
+		case op.isInsert():
+			newIndex += utf8.RuneCountInString(op.Insert)
+		case op.isDelete():
+			deleted := index - pos
+			if deleted > op.Delete {
+				deleted = op.Delete
+			}
+			newIndex -= deleted
+			pos += op.Delete
+		}
+	}
+	return newIndex
+}
+
+// Diff returns an operation turning a into b, replacing what's between their common prefix and
+// suffix.
+func Diff(a, b string) Operation {
+	ra, rb := []rune(a), []rune(b)
+	prefix := 0
+	for prefix < len(ra) && prefix < len(rb) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(ra)-prefix && suffix < len(rb)-prefix && ra[len(ra)-1-suffix] == rb[len(rb)-1-suffix] {
+		suffix++
+	}
+	var o Operation
+	o = o.Retain(prefix)
+	o = o.Insert(string(rb[prefix : len(rb)-suffix]))
+	o = o.Delete(len(ra) - prefix - suffix)
+	return o.Retain(suffix)
+}
+
+// MarshalJSON encodes the operation in ot.js's format.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	components := make([]interface{}, 0, len(o))
+	for _, op := range o {
+		switch {
+		case op.isRetain():
+			components = append(components, op.Retain)
+		case op.isInsert():
+			components = append(components, op.Insert)
+		case op.isDelete():
+			components = append(components, -op.Delete)
+		}
+	}
+	return json.Marshal(components)
+}
+
+// UnmarshalJSON decodes an operation in ot.js's format. Operations whose base length overflows are
+// rejected, those too long for the text they're applied to fail with ErrBaseLength.
+func (o *Operation) UnmarshalJSON(b []byte) error {
+	var components []json.RawMessage
+	if err := json.Unmarshal(b, &components); err != nil {
+		return err
+	}
+	var op Operation
+	base := 0
+	for _, c := range components {
+		var s string
+		if err := json.Unmarshal(c, &s); err == nil {
+			op = op.Insert(s)
+			continue
+		}
+		var n int
+		if err := json.Unmarshal(c, &n); err != nil || n == 0 {
+			return fmt.Errorf("ot: invalid operation component: %s", c)
+		}
+		count := n
+		if n < 0 {
+			count = -n
+		}
+		// the negation of the smallest int is itself.
+		if count < 0 || count > maxInt-base {
+			return errTooLong
+		}
+		base += count
+		if n > 0 {
+			op = op.Retain(n)
+		} else {
+			op = op.Delete(-n)
+		}
+	}
+	*o = op
+	return nil
+}
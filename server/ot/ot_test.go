@@ -0,0 +1,202 @@
+package ot_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/ot"
+)
+
+func TestApply(t *testing.T) {
+	var o ot.Operation
+	o = o.Retain(6).Delete(5).Insert("gophers").Retain(1)
+	text, err := o.Apply("hello world!")
+	require.NoError(t, err)
+	require.Equal(t, "hello gophers!", text)
+
+	_, err = o.Apply("hello")
+	require.Equal(t, ot.ErrBaseLength, err)
+
+	o = ot.Operation{}.Retain(1).Insert("é")
+	text, err = o.Apply("ü")
+	require.NoError(t, err)
+	require.Equal(t, "üé", text)
+}
+
+func TestInvalidLength(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		// unmarshalErr is whether decoding fails, otherwise applying it fails.
+		unmarshalErr bool
+	}{
+		{name: "retain past the end", json: `[9223372036854775807]`},
+		{name: "delete past the end", json: `[-9223372036854775807]`},
+		{name: "retain past the end after an insert", json: `["x", 9223372036854775807]`},
+		{name: "retain and delete past the end", json: `[1, -9223372036854775806]`},
+		{name: "retains overflow to the text's length", json: `[9223372036854775807, "x", 9223372036854775807, 7]`, unmarshalErr: true},
+		{name: "retain and delete overflow", json: `[3, -9223372036854775807]`, unmarshalErr: true},
+		{name: "smallest delete", json: `[-9223372036854775808]`, unmarshalErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o ot.Operation
+			err := json.Unmarshal([]byte(tt.json), &o)
+			if tt.unmarshalErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_, err = o.Apply("hello")
+			require.Equal(t, ot.ErrBaseLength, err)
+			_, _, err = ot.Transform(o, ot.Operation{}.Retain(5))
+			require.Error(t, err)
+		})
+	}
+
+	// operations built to overflow to the text's length don't apply either
+	o := ot.Operation{{Retain: math.MaxInt64}, {Insert: "x"}, {Retain: math.MaxInt64}, {Retain: 7}}
+	require.Equal(t, -1, o.BaseLen())
+	_, err := o.Apply("hello")
+	require.Equal(t, ot.ErrBaseLength, err)
+	_, _, err = ot.Transform(o, o)
+	require.Error(t, err)
+}
+
+func TestJSON(t *testing.T) {
+	var o ot.Operation
+	require.NoError(t, json.Unmarshal([]byte(`[2, "ab", -3, 1]`), &o))
+	require.Equal(t, ot.Operation{}.Retain(2).Insert("ab").Delete(3).Retain(1), o)
+
+	b, err := json.Marshal(o)
+	require.NoError(t, err)
+	require.JSONEq(t, `[2, "ab", -3, 1]`, string(b))
+
+	require.Error(t, json.Unmarshal([]byte(`[0]`), &o))
+	require.Error(t, json.Unmarshal([]byte(`[true]`), &o))
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		a, b ot.Operation
+		want string
+	}{
+		{
+			name: "inserts at different positions",
+			text: "abc",
+			a:    ot.Operation{}.Insert("x").Retain(3),
+			b:    ot.Operation{}.Retain(3).Insert("y"),
+			want: "xabcy",
+		},
+		{
+			name: "inserts at the same position",
+			text: "abc",
+			a:    ot.Operation{}.Retain(1).Insert("x").Retain(2),
+			b:    ot.Operation{}.Retain(1).Insert("y").Retain(2),
+			want: "axybc",
+		},
+		{
+			name: "overlapping deletes",
+			text: "abcdef",
+			a:    ot.Operation{}.Retain(1).Delete(3).Retain(2),
+			b:    ot.Operation{}.Retain(2).Delete(3).Retain(1),
+			want: "af",
+		},
+		{
+			name: "insert inside a delete",
+			text: "abcdef",
+			a:    ot.Operation{}.Retain(1).Delete(4).Retain(1),
+			b:    ot.Operation{}.Retain(3).Insert("x").Retain(3),
+			want: "axf",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a1, b1, err := ot.Transform(test.a, test.b)
+			require.NoError(t, err)
+			require.Equal(t, test.want, applyAll(t, test.text, test.a, b1))
+			require.Equal(t, test.want, applyAll(t, test.text, test.b, a1))
+		})
+	}
+
+	_, _, err := ot.Transform(ot.Operation{}.Retain(1), ot.Operation{}.Retain(2))
+	require.Error(t, err)
+}
+
+func TestTransformConverges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		text := randomText(r, r.Intn(20))
+		a, b := randomOperation(r, text), randomOperation(r, text)
+		a1, b1, err := ot.Transform(a, b)
+		require.NoError(t, err)
+		require.Equal(t, applyAll(t, text, a, b1), applyAll(t, text, b, a1))
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	o := ot.Operation{}.Insert("ab").Retain(2).Delete(2).Retain(2)
+	require.Equal(t, 2, ot.TransformIndex(0, o))
+	require.Equal(t, 4, ot.TransformIndex(2, o))
+	require.Equal(t, 4, ot.TransformIndex(3, o))
+	require.Equal(t, 5, ot.TransformIndex(5, o))
+}
+
+func TestDiff(t *testing.T) {
+	for _, test := range [][2]string{
+		{"", ""},
+		{"", "abc"},
+		{"abc", ""},
+		{"hello world", "hello there world"},
+		{"aaa", "aa"},
+		{"naïve", "naive"},
+	} {
+		o := ot.Diff(test[0], test[1])
+		require.Equal(t, test[1], applyAll(t, test[0], o))
+	}
+}
+
+func applyAll(t *testing.T, text string, ops ...ot.Operation) string {
+	t.Helper()
+	var err error
+	for _, o := range ops {
+		text, err = o.Apply(text)
+		require.NoError(t, err)
+	}
+	return text
+}
+
+func randomText(r *rand.Rand, n int) string {
+	runes := make([]rune, n)
+	for i := range runes {
+		runes[i] = []rune("abcé ")[r.Intn(5)]
+	}
+	return string(runes)
+}
+
+func randomOperation(r *rand.Rand, text string) ot.Operation {
+	var o ot.Operation
+	left := len([]rune(text))
+	for left > 0 {
+		n := r.Intn(left) + 1
+		switch r.Intn(3) {
+		case 0:
+			o = o.Retain(n)
+		case 1:
+			o = o.Delete(n)
+		default:
+			o = o.Insert(randomText(r, n))
+			continue
+		}
+		left -= n
+	}
+	if r.Intn(2) == 0 {
+		o = o.Insert(randomText(r, r.Intn(3)))
+	}
+	return o
+}
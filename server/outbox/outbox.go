@@ -0,0 +1,185 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/travisjeffery/writegood/server/mailer"
+)
+
+const (
+	statusPending = "pending"
+	statusSent    = "sent"
+	statusFailed  = "failed"
+)
+
+// Outbox is a Mailer that queues emails in the email_outbox table so the caller doesn't wait on
+// delivery. The Worker delivers the queued emails.
+type Outbox struct {
+	DB *pgxpool.Pool
+}
+
+// Send queues the message for delivery.
+func (o *Outbox) Send(ctx context.Context, m mailer.Message) error {
+	_, err := o.DB.Exec(
+		ctx,
+		`insert into email_outbox (from_name, from_account, to_account, subject, plain, html) values ($1, $2, $3, $4, $5, $6)`,
+		m.FromName,
+		m.FromAccount,
+		m.To,
+		m.Subject,
+		m.Plain,
+		m.HTML,
+	)
+	return err
+}
+
+// Worker delivers queued emails with Mailer, retrying failures with exponential backoff until
+// MaxAttempts is reached and the email is marked failed.
+type Worker struct {
+	DB           *pgxpool.Pool
+	Mailer       mailer.Mailer
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// Lease is how long a claimed email is hidden from other workers while it's being sent.
+	Lease time.Duration
+}
+
+// Run delivers queued emails until the context is done.
+func (w *Worker) Run(ctx context.Context) {
+	w.defaults()
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.DeliverPending(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[error] failed to deliver pending emails: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type email struct {
+	id       int
+	attempts int
+	msg      mailer.Message
+}
+
+// DeliverPending claims a batch of emails that are due and tries to send them.
+func (w *Worker) DeliverPending(ctx context.Context) error {
+	w.defaults()
+	// claim by pushing next_attempt out by the lease so concurrent workers skip these rows.
+	rows, err := w.DB.Query(
+		ctx,
+		`update email_outbox set attempts = attempts + 1, next_attempt = $1, updated = $2
+		where id in (
+			select id from email_outbox where status = $3 and next_attempt <= $2
+			order by next_attempt limit $4 for update skip locked
+		)
+		returning id, attempts, from_name, from_account, to_account, subject, plain, html`,
+		time.Now().Add(w.Lease),
+		time.Now(),
+		statusPending,
+		w.BatchSize,
+	)
+	if err != nil {
+		return err
+	}
+	var emails []email
+	for rows.Next() {
+		var e email
+		if err = rows.Scan(&e.id, &e.attempts, &e.msg.FromName, &e.msg.FromAccount, &e.msg.To, &e.msg.Subject, &e.msg.Plain, &e.msg.HTML); err != nil {
+			rows.Close()
+			return err
+		}
+		emails = append(emails, e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range emails {
+		if err = w.deliver(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends the email and records the outcome of the attempt.
+func (w *Worker) deliver(ctx context.Context, e email) error {
+	sendErr := w.Mailer.Send(ctx, e.msg)
+	if sendErr == nil {
+		log.Printf("[debug] sent email: %d to: %s", e.id, e.msg.To)
+		_, err := w.DB.Exec(
+			ctx,
+			`update email_outbox set status = $1, sent = $2, updated = $2, last_error = null where id = $3`,
+			statusSent,
+			time.Now(),
+			e.id,
+		)
+		return err
+	}
+
+	status := statusPending
+	if e.attempts >= w.MaxAttempts {
+		status = statusFailed
+		log.Printf("[error] giving up on email: %d to: %s after %d attempts: %v", e.id, e.msg.To, e.attempts, sendErr)
+	} else {
+		log.Printf("[error] failed to send email: %d to: %s attempt: %d: %v", e.id, e.msg.To, e.attempts, sendErr)
+	}
+	_, err := w.DB.Exec(
+		ctx,
+		`update email_outbox set status = $1, last_error = $2, next_attempt = $3, updated = $4 where id = $5`,
+		status,
+		sendErr.Error(),
+		time.Now().Add(Backoff(e.attempts, w.BaseDelay, w.MaxDelay)),
+		time.Now(),
+		e.id,
+	)
+	return err
+}
+
+func (w *Worker) defaults() {
+	if w.PollInterval == 0 {
+		w.PollInterval = 5 * time.Second
+	}
+	if w.BatchSize == 0 {
+		w.BatchSize = 10
+	}
+	if w.MaxAttempts == 0 {
+		w.MaxAttempts = 8
+	}
+	if w.BaseDelay == 0 {
+		w.BaseDelay = 10 * time.Second
+	}
+	if w.MaxDelay == 0 {
+		w.MaxDelay = time.Hour
+	}
+	if w.Lease == 0 {
+		w.Lease = 5 * time.Minute
+	}
+}
+
+// Backoff returns how long to wait before retrying after the given number of attempts: base
+// doubled for each attempt after the first, capped at max.
+func Backoff(attempts int, base, max time.Duration) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := float64(base) * math.Pow(2, float64(attempts-1))
+	if d > float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
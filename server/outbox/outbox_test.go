@@ -0,0 +1,21 @@
+package outbox_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/outbox"
+)
+
+func TestBackoff(t *testing.T) {
+	base := 10 * time.Second
+	max := time.Minute
+
+	require.Equal(t, 10*time.Second, outbox.Backoff(0, base, max))
+	require.Equal(t, 10*time.Second, outbox.Backoff(1, base, max))
+	require.Equal(t, 20*time.Second, outbox.Backoff(2, base, max))
+	require.Equal(t, 40*time.Second, outbox.Backoff(3, base, max))
+	require.Equal(t, max, outbox.Backoff(4, base, max))
+	require.Equal(t, max, outbox.Backoff(100, base, max))
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// webAuthnSession holds the challenge of a passkey registration or sign in between its begin and
+// finish requests.
+const webAuthnSession = "webauthn_session"
+
+// webAuthnTimeout is how long the user has to respond to a challenge.
+const webAuthnTimeout = 5 * time.Minute
+
+// WebAuthnCredential is a passkey a user registered to sign in with.
+type WebAuthnCredential struct {
+	ID        []byte     `json:"id"`
+	UserID    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	PublicKey []byte     `json:"-"`
+	SignCount uint32     `json:"sign_count"`
+	Created   time.Time  `json:"created"`
+	LastUsed  *time.Time `json:"last_used"`
+}
+
+// webAuthnChallenge is a challenge saved to the session between the begin and finish requests.
+type webAuthnChallenge struct {
+	challenge []byte
+	// userID is the user registering a passkey, zero for sign ins.
+	userID  int
+	created time.Time
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// HandleWebAuthnRegisterBegin returns the options for navigator.credentials.create() so the signed
+// in user can register a passkey.
+func (s *Server) HandleWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user := s.sessionUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	challenge, ok := s.newWebAuthnChallenge(w, r, user.ID)
+	if !ok {
+		return
+	}
+	creds, err := s.Credentials.FindWebAuthnCredentialsByUser(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("[error] failed to find webauthn credentials: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	exclude := []credentialDescriptor{}
+	for _, c := range creds {
+		exclude = append(exclude, credentialDescriptor{Type: "public-key", ID: webauthn.Encode(c.ID)})
+	}
+	params := []map[string]interface{}{}
+	for _, alg := range webauthn.Algorithms {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+	writeJSON(w, map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge": webauthn.Encode(challenge),
+			"rp": map[string]string{
+				"id":   s.relyingParty.ID,
+				"name": "Write Good",
+			},
+			"user": map[string]string{
+				"id":          webauthn.Encode(webAuthnUserHandle(user.ID)),
+				"name":        user.Email,
+				"displayName": user.Email,
+			},
+			"pubKeyCredParams":   params,
+			"timeout":            webAuthnTimeout.Nanoseconds() / int64(time.Millisecond),
+			"attestation":        "none",
+			"excludeCredentials": exclude,
+			"authenticatorSelection": map[string]string{
+				"residentKey":      "preferred",
+				"userVerification": "preferred",
+			},
+		},
+	})
+}
+
+// HandleWebAuthnRegisterFinish verifies and saves the passkey the signed in user created.
+func (s *Server) HandleWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	user := s.sessionUser(r)
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body struct {
+		Name       string                       `json:"name"`
+		Credential webauthn.AttestationResponse `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	challenge, ok := s.takeWebAuthnChallenge(w, r)
+	if !ok {
+		return
+	}
+	if challenge.userID != user.ID {
+		log.Printf("[error] webauthn registration challenge for user: %d used by user: %d", challenge.userID, user.ID)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cred, err := s.relyingParty.VerifyRegistration(body.Credential, challenge.challenge)
+	if err != nil {
+		log.Printf("[error] failed to verify webauthn registration: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	created, err := s.Credentials.CreateWebAuthnCredential(r.Context(), WebAuthnCredential{
+		ID:        cred.ID,
+		UserID:    user.ID,
+		Name:      body.Name,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+	})
+	if err != nil {
+		log.Printf("[error] failed to create webauthn credential: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[debug] registered webauthn credential for user: %d", user.ID)
+	writeJSON(w, created)
+}
+
+// HandleWebAuthnSignInBegin returns the options for navigator.credentials.get(). No credentials
+// are listed so the authenticator offers its passkeys for the site and we don't reveal accounts.
+func (s *Server) HandleWebAuthnSignInBegin(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := s.newWebAuthnChallenge(w, r, 0)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge":        webauthn.Encode(challenge),
+			"rpId":             s.relyingParty.ID,
+			"timeout":          webAuthnTimeout.Nanoseconds() / int64(time.Millisecond),
+			"userVerification": "preferred",
+			"allowCredentials": []credentialDescriptor{},
+		},
+	})
+}
+
+// HandleWebAuthnSignInFinish verifies the passkey's assertion and signs its user in.
+func (s *Server) HandleWebAuthnSignInFinish(w http.ResponseWriter, r *http.Request) {
+	var res webauthn.AssertionResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	challenge, ok := s.takeWebAuthnChallenge(w, r)
+	if !ok {
+		return
+	}
+	id, err := res.CredentialID()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cred, err := s.Credentials.FindWebAuthnCredential(r.Context(), id)
+	if err == ErrNotFound {
+		log.Printf("[error] unknown webauthn credential")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to find webauthn credential: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if res.Response.UserHandle != "" {
+		handle, err := webauthn.Decode(res.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, webAuthnUserHandle(cred.UserID)) {
+			log.Printf("[error] webauthn user handle mismatch for user: %d", cred.UserID)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	signCount, err := s.relyingParty.VerifyAssertion(res, challenge.challenge, webauthn.Credential{
+		ID:        cred.ID,
+		PublicKey: cred.PublicKey,
+		SignCount: cred.SignCount,
+	})
+	if err == webauthn.ErrSignCount {
+		log.Printf("[error] webauthn sign count for user: %d went from: %d to: %d, the authenticator may be cloned", cred.UserID, cred.SignCount, signCount)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to verify webauthn assertion: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// the session's cookie can be sent again after it's cleared, so a challenge is only good until
+	// the passkey's next used.
+	if cred.LastUsed != nil && !cred.LastUsed.Before(challenge.created) {
+		log.Printf("[error] replayed webauthn challenge for user: %d", cred.UserID)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	err = s.Credentials.UpdateWebAuthnCredentialSignCount(r.Context(), cred.ID, signCount, challenge.created)
+	if err == webauthn.ErrSignCount {
+		log.Printf("[error] webauthn credential for user: %d was used concurrently", cred.UserID)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to update webauthn credential: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.Users.FindUserByID(r.Context(), cred.UserID)
+	if err != nil {
+		log.Printf("[error] failed to find user by id: %d: %v", cred.UserID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	signedIn, err := s.Users.UpdateUserSignedIn(r.Context(), user.ID, time.Now())
+	if err != nil {
+		log.Printf("[error] failed to update user signed in: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user.SignedIn = &signedIn
+	if err = s.startSession(w, r, &user); err != nil {
+		log.Printf("[error] failed to start session: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	log.Printf("[debug] signed in user: %d with webauthn", user.ID)
+	writeJSON(w, user)
+}
+
+// newWebAuthnChallenge creates a challenge and saves it to the session for the finish request.
+func (s *Server) newWebAuthnChallenge(w http.ResponseWriter, r *http.Request, userID int) ([]byte, bool) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		log.Printf("[error] failed to create webauthn challenge: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	session, err := s.sessions.Get(r, webAuthnSession)
+	if err != nil {
+		log.Printf("[debug] replacing bad webauthn session: %v", err)
+	}
+	session.Values["challenge"] = challenge
+	session.Values["user_id"] = userID
+	session.Values["created"] = time.Now().UnixNano()
+	session.Options.MaxAge = int(webAuthnTimeout.Seconds())
+	if err = s.sessions.Save(r, w, session); err != nil {
+		log.Printf("[error] failed to save session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	return challenge, true
+}
+
+// takeWebAuthnChallenge returns the session's challenge and clears it so it can only be used once.
+func (s *Server) takeWebAuthnChallenge(w http.ResponseWriter, r *http.Request) (webAuthnChallenge, bool) {
+	var c webAuthnChallenge
+	session, err := s.sessions.Get(r, webAuthnSession)
+	if err != nil {
+		log.Printf("[error] failed to get webauthn session: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return c, false
+	}
+	c.challenge, _ = session.Values["challenge"].([]byte)
+	c.userID, _ = session.Values["user_id"].(int)
+	created, _ := session.Values["created"].(int64)
+	c.created = time.Unix(0, created)
+	session.Options.MaxAge = -1
+	if err = s.sessions.Save(r, w, session); err != nil {
+		log.Printf("[error] failed to save session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return c, false
+	}
+	if len(c.challenge) == 0 || time.Since(c.created) > webAuthnTimeout {
+		w.WriteHeader(http.StatusBadRequest)
+		return c, false
+	}
+	return c, true
+}
+
+// webAuthnUserHandle is the opaque user id given to authenticators.
+func webAuthnUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[error] failed to encode json: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+)
+
+// DocumentRole is what a user can do with a document. Each role can do everything the ones before
+// it can.
+type DocumentRole string
+
+const (
+	// RoleViewer can read the document.
+	RoleViewer DocumentRole = "viewer"
+	// RoleCommenter can read the document and comment on it.
+	RoleCommenter DocumentRole = "commenter"
+	// RoleEditor can change the document's text and details.
+	RoleEditor DocumentRole = "editor"
+	// RoleOwner can share, archive and trash the document. The document's author is always an
+	// owner.
+	RoleOwner DocumentRole = "owner"
+)
+
+var roleRanks = map[DocumentRole]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// allows returns whether the role can do what the other role can.
+func (r DocumentRole) allows(other DocumentRole) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+var (
+	errForbidden    = &graphqlError{code: "FORBIDDEN", message: "you don't have permission to do that"}
+	errShareAuthor  = &graphqlError{code: "SHARE_WITH_AUTHOR", message: "the document's author is always its owner"}
+	errUnknownEmail = &graphqlError{code: "UNKNOWN_EMAIL", message: "there's no user with that email"}
+)
+
+// documentRole returns the user's role on the document, owner if they're its author. It returns
+// ErrNotFound if the document isn't shared with them.
+func (s *Server) documentRole(ctx context.Context, userID int, d Document) (DocumentRole, error) {
+	if d.AuthorID == userID {
+		return RoleOwner, nil
+	}
+	permission, err := s.Documents.FindDocumentPermission(ctx, d.ID, userID)
+	if err != nil {
+		return "", err
+	}
+	return permission.Role, nil
+}
+
+// authorizeDocument returns the document if the viewer has the role on it. Documents the viewer
+// has no role on aren't found so their ids aren't leaked, those they have a lesser role on return
+// errForbidden.
+func (s *Server) authorizeDocument(ctx context.Context, id int, role DocumentRole) (Document, error) {
+	v, err := viewerFromContext(ctx)
+	if err != nil {
+		return Document{}, err
+	}
+	d, err := s.Documents.FindDocumentByID(ctx, id)
+	if err != nil {
+		return Document{}, err
+	}
+	has, err := s.documentRole(ctx, v.User.ID, d)
+	if err != nil {
+		return Document{}, err
+	}
+	if !has.allows(role) {
+		return Document{}, errForbidden
+	}
+	return d, nil
+}
+
+// authorizeUser returns errForbidden unless the viewer is the user, for fields listing what's the
+// user's.
+func authorizeUser(ctx context.Context, user User) error {
+	v, err := viewerFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if v.User.ID != user.ID {
+		return errForbidden
+	}
+	return nil
+}
+
+// shareDocument gives the user with the email the role on the viewer's document. The viewer must
+// be one of the document's owners. Users whose new role can't edit are disconnected from editing it.
+func (s *Server) shareDocument(ctx context.Context, id int, email string, role DocumentRole) (DocumentPermission, error) {
+	d, err := s.authorizeDocument(ctx, id, RoleOwner)
+	if err != nil {
+		return DocumentPermission{}, err
+	}
+	user, err := s.Users.FindUserByEmail(ctx, email)
+	if err == ErrNotFound {
+		return DocumentPermission{}, errUnknownEmail
+	}
+	if err != nil {
+		return DocumentPermission{}, err
+	}
+	if user.ID == d.AuthorID {
+		return DocumentPermission{}, errShareAuthor
+	}
+	permission, err := s.Documents.ShareDocument(ctx, id, user.ID, role)
+	if err != nil {
+		return permission, err
+	}
+	if !role.allows(RoleEditor) {
+		s.collab.evict(id, user.ID)
+	}
+	return permission, nil
+}
+
+// unshareDocument removes the role the user with the email has on the viewer's document and
+// disconnects them from editing it. The viewer must be one of the document's owners, or the user
+// themselves.
+func (s *Server) unshareDocument(ctx context.Context, id int, email string) error {
+	v, err := viewerFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	user, err := s.Users.FindUserByEmail(ctx, email)
+	if err == ErrNotFound {
+		return errUnknownEmail
+	}
+	if err != nil {
+		return err
+	}
+	role := RoleOwner
+	if user.ID == v.User.ID {
+		role = RoleViewer
+	}
+	d, err := s.authorizeDocument(ctx, id, role)
+	if err != nil {
+		return err
+	}
+	if user.ID == d.AuthorID {
+		return errShareAuthor
+	}
+	if err = s.Documents.UnshareDocument(ctx, id, user.ID); err != nil {
+		return err
+	}
+	s.collab.evict(id, user.ID)
+	return nil
+}
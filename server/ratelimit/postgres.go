@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Postgres is a token bucket limiter stored in the rate_limits table, so the limits are shared by
+// every instance using the database. Each key gets Burst tokens and a token is refilled every
+// Every. Rows record when their bucket will have refilled even if it was empty, so limiters with
+// different rates can share the table and prune it.
+type Postgres struct {
+	DB    *pgxpool.Pool
+	Every time.Duration
+	Burst int
+}
+
+// Allow takes a token for the key if there's one available. The refill and take happen in a
+// single upsert against the locked row so concurrent callers can't both take the last token, and
+// the row records whether this take was allowed.
+func (p *Postgres) Allow(ctx context.Context, key string) (bool, error) {
+	var allowed bool
+	err := p.DB.QueryRow(
+		ctx,
+		`insert into rate_limits as r (key, tokens, allowed, updated, refilled) values ($1, $2::double precision - 1, $2 >= 1, $3, $3 + $2 * $4 * interval '1 second')
+		on conflict (key) do update set
+			tokens = case
+				when least($2, r.tokens + extract(epoch from ($3 - r.updated)) / $4) >= 1
+				then least($2, r.tokens + extract(epoch from ($3 - r.updated)) / $4) - 1
+				else least($2, r.tokens + extract(epoch from ($3 - r.updated)) / $4)
+			end,
+			allowed = least($2, r.tokens + extract(epoch from ($3 - r.updated)) / $4) >= 1,
+			updated = $3,
+			refilled = $3 + $2 * $4 * interval '1 second'
+		returning allowed`,
+		key,
+		float64(p.Burst),
+		time.Now(),
+		p.Every.Seconds(),
+	).Scan(&allowed)
+	return allowed, err
+}
+
+// Prune deletes the rows whose buckets have refilled.
+func (p *Postgres) Prune(ctx context.Context) (int, error) {
+	tag, err := p.DB.Exec(ctx, `delete from rate_limits where refilled < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limiter limits how often an action keyed by key may happen.
+type Limiter interface {
+	// Allow takes a token for the key and returns whether the action is allowed.
+	Allow(ctx context.Context, key string) (bool, error)
+	// Prune deletes the buckets that have refilled completely, since they're the same as new
+	// buckets, and returns how many there were.
+	Prune(ctx context.Context) (int, error)
+}
+
+// maxBuckets is how many buckets Memory holds before pruning the full ones, if that's not enough
+// the least recently used ones are dropped too.
+const maxBuckets = 10000
+
+// Memory is a token bucket limiter held in memory, so it only limits a single instance. Each key
+// gets Burst tokens and a token is refilled every Every.
+type Memory struct {
+	Every time.Duration
+	Burst int
+	// Now returns the current time, defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Allow takes a token for the key if there's one available.
+func (m *Memory) Allow(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.Now != nil {
+		now = m.Now()
+	}
+	if m.buckets == nil {
+		m.buckets = make(map[string]*bucket)
+	}
+	if len(m.buckets) >= maxBuckets {
+		m.prune(now)
+	}
+	if len(m.buckets) >= maxBuckets {
+		m.evict()
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(m.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, now.Sub(b.updated), m.Every, m.Burst)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+// Prune drops the buckets that have refilled completely.
+func (m *Memory) Prune(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.Now != nil {
+		now = m.Now()
+	}
+	return m.prune(now), nil
+}
+
+// prune drops buckets that have refilled completely since they're the same as new buckets.
+func (m *Memory) prune(now time.Time) int {
+	n := 0
+	for key, b := range m.buckets {
+		if refill(b.tokens, now.Sub(b.updated), m.Every, m.Burst) >= float64(m.Burst) {
+			delete(m.buckets, key)
+			n++
+		}
+	}
+	return n
+}
+
+// evict drops the least recently used buckets until there's a tenth of maxBuckets free, so it
+// isn't done for every new key.
+func (m *Memory) evict() {
+	keys := make([]string, 0, len(m.buckets))
+	for key := range m.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return m.buckets[keys[i]].updated.Before(m.buckets[keys[j]].updated) })
+	for _, key := range keys[:len(keys)-maxBuckets*9/10] {
+		delete(m.buckets, key)
+	}
+}
+
+func refill(tokens float64, elapsed, every time.Duration, burst int) float64 {
+	tokens += float64(elapsed) / float64(every)
+	if tokens > float64(burst) {
+		return float64(burst)
+	}
+	return tokens
+}
@@ -0,0 +1,90 @@
+package ratelimit_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/ratelimit"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := &ratelimit.Memory{
+		Every: time.Minute,
+		Burst: 2,
+		Now:   func() time.Time { return now },
+	}
+
+	allow := func(key string) bool {
+		ok, err := limiter.Allow(ctx, key)
+		require.NoError(t, err)
+		return ok
+	}
+
+	// burst
+	require.True(t, allow("a"))
+	require.True(t, allow("a"))
+	require.False(t, allow("a"))
+
+	// keys have their own buckets
+	require.True(t, allow("b"))
+
+	// refill one token
+	now = now.Add(time.Minute)
+	require.True(t, allow("a"))
+	require.False(t, allow("a"))
+
+	// refill caps at burst
+	now = now.Add(time.Hour)
+	require.True(t, allow("a"))
+	require.True(t, allow("a"))
+	require.False(t, allow("a"))
+}
+
+func TestMemoryPrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := &ratelimit.Memory{
+		Every: time.Minute,
+		Burst: 2,
+		Now:   func() time.Time { return now },
+	}
+	allow := func(key string) bool {
+		ok, err := limiter.Allow(ctx, key)
+		require.NoError(t, err)
+		return ok
+	}
+
+	require.True(t, allow("a"))
+	require.True(t, allow("a"))
+	require.True(t, allow("b"))
+
+	// b has refilled, a still has a token to go
+	now = now.Add(time.Minute)
+	n, err := limiter.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	now = now.Add(time.Minute)
+	n, err = limiter.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// when there are too many keys the least recently used are dropped, the limits of recently used
+	// keys are kept.
+	for i := 0; i < 10000; i++ {
+		require.True(t, allow(fmt.Sprintf("old-%d", i)))
+	}
+	now = now.Add(time.Second)
+	require.True(t, allow("a"))
+	require.True(t, allow("a"))
+	require.False(t, allow("a"))
+	now = now.Add(time.Second)
+	for i := 0; i < 5000; i++ {
+		require.True(t, allow(fmt.Sprintf("new-%d", i)))
+	}
+	require.False(t, allow("a"))
+}
@@ -0,0 +1,1175 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// jsonType is a scalar for free-form JSON values like document metadata.
+var jsonType = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "A JSON value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: parseJSONLiteral,
+})
+
+// parseJSONLiteral returns the Go value of a literal JSON argument.
+func parseJSONLiteral(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.ObjectValue:
+		obj := make(map[string]interface{})
+		for _, f := range v.Fields {
+			obj[f.Name.Value] = parseJSONLiteral(f.Value)
+		}
+		return obj
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, parseJSONLiteral(item))
+		}
+		return list
+	case *ast.IntValue:
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.StringValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	}
+	return nil
+}
+
+// documentArgs are the optional document fields createDocument and updateDocument take.
+var documentArgs = graphql.FieldConfigArgument{
+	"title": &graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "Leave empty to use the first heading.",
+	},
+	"slug": &graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "Leave empty to make one from the title.",
+	},
+	"description": &graphql.ArgumentConfig{
+		Type: graphql.String,
+	},
+	"tags": &graphql.ArgumentConfig{
+		Type: graphql.NewList(graphql.NewNonNull(graphql.String)),
+	},
+	"metadata": &graphql.ArgumentConfig{
+		Type:        jsonType,
+		Description: "A JSON object of anything else to keep with the document.",
+	},
+}
+
+// documentUpdateFromArgs returns the update for the document fields set in the args.
+func documentUpdateFromArgs(args map[string]interface{}) DocumentUpdate {
+	var u DocumentUpdate
+	str := func(name string) *string {
+		if v, ok := args[name].(string); ok {
+			return &v
+		}
+		return nil
+	}
+	u.Text = str("text")
+	u.Title = str("title")
+	u.Description = str("description")
+	if slug := str("slug"); slug != nil {
+		normalized := slugify(*slug)
+		u.Slug = &normalized
+	}
+	if tags, ok := args["tags"].([]interface{}); ok {
+		var strs []string
+		for _, tag := range tags {
+			strs = append(strs, tag.(string))
+		}
+		u.Tags = normalizeTags(strs)
+	}
+	if metadata, ok := args["metadata"].(map[string]interface{}); ok {
+		u.Metadata = metadata
+	}
+	return u
+}
+
+// intArg returns the int arg, or nil if it isn't set.
+func intArg(args map[string]interface{}, name string) *int {
+	if v, ok := args[name].(int); ok {
+		return &v
+	}
+	return nil
+}
+
+// documentFilterFromArgs returns the filter for the documentsConnection args.
+func documentFilterFromArgs(args map[string]interface{}) (DocumentFilter, error) {
+	filter := DocumentFilter{
+		State: DocumentActive,
+		Sort:  args["sort"].(DocumentSort),
+		Desc:  args["desc"].(bool),
+		Limit: args["first"].(int),
+	}
+	if filter.Limit < 1 || filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+	if after, ok := args["after"].(string); ok {
+		c, err := decodeCursor(after)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = c
+	}
+	in, _ := args["filter"].(map[string]interface{})
+	if state, ok := in["state"].(DocumentState); ok {
+		filter.State = state
+	}
+	filter.Tag, _ = in["tag"].(string)
+	filter.FolderID = intArg(in, "folder_id")
+	times := map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+		"updated_after":  &filter.UpdatedAfter,
+		"updated_before": &filter.UpdatedBefore,
+	}
+	for name, t := range times {
+		v, ok := in[name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, errInvalidTime
+		}
+		*t = parsed
+	}
+	return filter, nil
+}
+
+// newSchema returns the GraphQL schema resolved with the server's stores.
+func (s *Server) newSchema() (graphql.Schema, error) {
+	var documentStateType = graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "DocumentState",
+			Values: graphql.EnumValueConfigMap{
+				"ACTIVE": &graphql.EnumValueConfig{
+					Value:       DocumentActive,
+					Description: "In use and listed by default.",
+				},
+				"ARCHIVED": &graphql.EnumValueConfig{
+					Value:       DocumentArchived,
+					Description: "Kept but hidden from default listings.",
+				},
+				"TRASHED": &graphql.EnumValueConfig{
+					Value:       DocumentTrashed,
+					Description: "In the trash until it's restored or purged.",
+				},
+			},
+		},
+	)
+
+	var documentRoleType = graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "DocumentRole",
+			Values: graphql.EnumValueConfigMap{
+				"VIEWER": &graphql.EnumValueConfig{
+					Value:       RoleViewer,
+					Description: "Can read the document.",
+				},
+				"COMMENTER": &graphql.EnumValueConfig{
+					Value:       RoleCommenter,
+					Description: "Can read the document and comment on it.",
+				},
+				"EDITOR": &graphql.EnumValueConfig{
+					Value:       RoleEditor,
+					Description: "Can change the document's text and details.",
+				},
+				"OWNER": &graphql.EnumValueConfig{
+					Value:       RoleOwner,
+					Description: "Can share, archive and trash the document.",
+				},
+			},
+		},
+	)
+
+	var documentPermissionType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "DocumentPermission",
+			Fields: graphql.Fields{
+				"role": &graphql.Field{
+					Type: graphql.NewNonNull(documentRoleType),
+				},
+				"created": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"updated": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	var shareLinkType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:        "ShareLink",
+			Description: "A link that shows a document to anyone who has it, without signing in.",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"document_id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"token": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The secret token, only returned when the link's created.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if token := p.Source.(ShareLink).Token; token != "" {
+							return token, nil
+						}
+						return nil, nil
+					},
+				},
+				"url": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The link to send, only returned when it's created.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						link := p.Source.(ShareLink)
+						if link.Token == "" {
+							return nil, nil
+						}
+						return s.shareLinkURL(link.Token), nil
+					},
+				},
+				"has_password": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(ShareLink).PasswordHash != "", nil
+					},
+				},
+				"expires": &graphql.Field{
+					Type: graphql.String,
+				},
+				"created": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"revoked": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var documentType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Document",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"text": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"created": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"updated": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"author_id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"folder_id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"version": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "Incremented each time the document's updated, pass it as updateDocument's expectedVersion.",
+				},
+				"title": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The document's title, or its first heading if it wasn't given one.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return documentTitle(p.Source.(Document)), nil
+					},
+				},
+				"slug": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The document's slug, or one made from its title if it wasn't given one.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return documentSlug(p.Source.(Document)), nil
+					},
+				},
+				"description": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"tags": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				},
+				"metadata": &graphql.Field{
+					Type: graphql.NewNonNull(jsonType),
+				},
+				"state": &graphql.Field{
+					Type: graphql.NewNonNull(documentStateType),
+				},
+				"trashed": &graphql.Field{
+					Type: graphql.String,
+				},
+				"purge_at": &graphql.Field{
+					Type:        graphql.String,
+					Description: "When the document will be deleted for good if it's left in the trash.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return s.purgeAt(p.Source.(Document)), nil
+					},
+				},
+				"role": &graphql.Field{
+					Type:        graphql.NewNonNull(documentRoleType),
+					Description: "The signed in user's role on the document, its author is an owner.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.documentRole(p.Context, v.User.ID, p.Source.(Document))
+					},
+				},
+				"permissions": &graphql.Field{
+					Type:        graphql.NewList(documentPermissionType),
+					Description: "Who the document's shared with besides its author.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return s.Documents.FindDocumentPermissions(p.Context, p.Source.(Document).ID)
+					},
+				},
+				"share_links": &graphql.Field{
+					Type:        graphql.NewList(shareLinkType),
+					Description: "The document's share links, only its owners can list them.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						d := p.Source.(Document)
+						if _, err := s.authorizeDocument(p.Context, d.ID, RoleOwner); err != nil {
+							return nil, err
+						}
+						return s.Documents.FindShareLinks(p.Context, d.ID)
+					},
+				},
+			},
+		},
+	)
+
+	var documentEdgeType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "DocumentEdge",
+			Fields: graphql.Fields{
+				"cursor": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"node": &graphql.Field{
+					Type: graphql.NewNonNull(documentType),
+				},
+			},
+		},
+	)
+
+	var pageInfoType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "PageInfo",
+			Fields: graphql.Fields{
+				"hasNextPage": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"hasPreviousPage": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"startCursor": &graphql.Field{
+					Type: graphql.String,
+				},
+				"endCursor": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var documentConnectionType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "DocumentConnection",
+			Fields: graphql.Fields{
+				"edges": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(documentEdgeType))),
+				},
+				"pageInfo": &graphql.Field{
+					Type: graphql.NewNonNull(pageInfoType),
+				},
+			},
+		},
+	)
+
+	var documentSortType = graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "DocumentSort",
+			Values: graphql.EnumValueConfigMap{
+				"CREATED": &graphql.EnumValueConfig{
+					Value: SortCreated,
+				},
+				"UPDATED": &graphql.EnumValueConfig{
+					Value: SortUpdated,
+				},
+				"TITLE": &graphql.EnumValueConfig{
+					Value:       SortTitle,
+					Description: "Case-insensitively by title, or first heading if there's no title.",
+				},
+			},
+		},
+	)
+
+	var documentFilterType = graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name:        "DocumentFilter",
+			Description: "Narrows a document listing, times are RFC 3339, e.g. 2006-01-02T15:04:05Z.",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"state": &graphql.InputObjectFieldConfig{
+					Type:         documentStateType,
+					DefaultValue: DocumentActive,
+				},
+				"tag": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"folder_id": &graphql.InputObjectFieldConfig{
+					Type:        graphql.Int,
+					Description: "Only documents in the folder, or in no folder if it's 0.",
+				},
+				"created_after": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"created_before": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"updated_after": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"updated_before": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var folderType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Folder",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"name": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"parent_id": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "The folder this one's in, null if it's at the top.",
+				},
+				"created": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"updated": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"documents": &graphql.Field{
+					Type:        graphql.NewList(documentType),
+					Description: "The documents in the folder that aren't archived or trashed.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						f := p.Source.(Folder)
+						filter := DocumentFilter{State: DocumentActive, FolderID: &f.ID}
+						return s.Documents.FindDocumentsByAuthor(p.Context, f.OwnerID, filter)
+					},
+				},
+			},
+		},
+	)
+	folderType.AddFieldConfig("folders", &graphql.Field{
+		Type:        graphql.NewList(folderType),
+		Description: "The folders in the folder.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			f := p.Source.(Folder)
+			folders, err := s.Folders.FindFoldersByOwner(p.Context, f.OwnerID)
+			if err != nil {
+				return nil, err
+			}
+			var children []Folder
+			for _, child := range folders {
+				if child.ParentID != nil && *child.ParentID == f.ID {
+					children = append(children, child)
+				}
+			}
+			return children, nil
+		},
+	})
+
+	var documentMatchType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "DocumentMatch",
+			Fields: graphql.Fields{
+				"document": &graphql.Field{
+					Type: graphql.NewNonNull(documentType),
+				},
+				"rank": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Float),
+					Description: "How well the document matches, higher is better.",
+				},
+				"snippet": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "HTML of the text around the matches with them wrapped in <mark> tags.",
+				},
+			},
+		},
+	)
+
+	var userType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "User",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"email": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+
+				"documentsConnection": &graphql.Field{
+					Type:        documentConnectionType,
+					Description: "Pages through the user's documents.",
+					Args: graphql.FieldConfigArgument{
+						"first": &graphql.ArgumentConfig{
+							Type:         graphql.Int,
+							DefaultValue: defaultPageSize,
+							Description:  fmt.Sprintf("At most %d.", maxPageSize),
+						},
+						"after": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+						"sort": &graphql.ArgumentConfig{
+							Type:         documentSortType,
+							DefaultValue: SortCreated,
+						},
+						"desc": &graphql.ArgumentConfig{
+							Type:         graphql.Boolean,
+							DefaultValue: false,
+						},
+						"filter": &graphql.ArgumentConfig{
+							Type: documentFilterType,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if err := authorizeUser(p.Context, p.Source.(User)); err != nil {
+							return nil, err
+						}
+						filter, err := documentFilterFromArgs(p.Args)
+						if err != nil {
+							return nil, err
+						}
+						return s.documentConnection(p.Context, p.Source.(User).ID, filter)
+					},
+				},
+				"documents": &graphql.Field{
+					Type:              graphql.NewList(documentType),
+					DeprecationReason: "Use documentsConnection, which pages.",
+					Args: graphql.FieldConfigArgument{
+						"state": &graphql.ArgumentConfig{
+							Type:         documentStateType,
+							DefaultValue: DocumentActive,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if err := authorizeUser(p.Context, p.Source.(User)); err != nil {
+							return nil, err
+						}
+						filter := DocumentFilter{State: p.Args["state"].(DocumentState)}
+						return s.Documents.FindDocumentsByAuthor(p.Context, p.Source.(User).ID, filter)
+					},
+				},
+			},
+		},
+	)
+
+	documentPermissionType.AddFieldConfig("user", &graphql.Field{
+		Type:        graphql.NewNonNull(userType),
+		Description: "Who the document's shared with.",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return s.Users.FindUserByID(p.Context, p.Source.(DocumentPermission).UserID)
+		},
+	})
+
+	var apiTokenScopeType = graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "APITokenScope",
+			Values: graphql.EnumValueConfigMap{
+				"READ": &graphql.EnumValueConfig{
+					Value:       scopeRead,
+					Description: "Can run queries.",
+				},
+				"WRITE": &graphql.EnumValueConfig{
+					Value:       scopeWrite,
+					Description: "Can run queries and mutations.",
+				},
+			},
+		},
+	)
+
+	var apiTokenType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "APIToken",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"name": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"scope": &graphql.Field{
+					Type: graphql.NewNonNull(apiTokenScopeType),
+				},
+				"token": &graphql.Field{
+					Type:        graphql.String,
+					Description: "The secret token, only returned when the token's created.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if token := p.Source.(APIToken).Token; token != "" {
+							return token, nil
+						}
+						return nil, nil
+					},
+				},
+				"created": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"last_used": &graphql.Field{
+					Type: graphql.String,
+				},
+				"revoked": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	var queryType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"user": &graphql.Field{
+					Type:        userType,
+					Description: "get the signed in user, by their id or email. Other users are forbidden whether they exist or not.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.Int,
+						},
+						"email": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						id, hasID := p.Args["id"].(int)
+						email, hasEmail := p.Args["email"].(string)
+						if !hasID && !hasEmail {
+							return nil, fmt.Errorf("neither id nor email arg set")
+						}
+						// other users aren't looked up, so which emails have accounts isn't leaked.
+						if (hasID && id != v.User.ID) || (hasEmail && normalizeEmail(email) != v.User.Email) {
+							return nil, errForbidden
+						}
+						return s.Users.FindUserByID(p.Context, v.User.ID)
+					},
+				},
+				"searchDocuments": &graphql.Field{
+					Type:        graphql.NewList(documentMatchType),
+					Description: "Search the documents the signed in user wrote or that are shared with them that aren't in the trash, best matches first.",
+					Args: graphql.FieldConfigArgument{
+						"query": &graphql.ArgumentConfig{
+							Type:        graphql.NewNonNull(graphql.String),
+							Description: `Words to match, "quoted phrases", OR and -excluded words are supported with Postgres.`,
+						},
+						"first": &graphql.ArgumentConfig{
+							Type:         graphql.Int,
+							DefaultValue: defaultPageSize,
+							Description:  fmt.Sprintf("At most %d.", maxPageSize),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						limit := p.Args["first"].(int)
+						if limit < 1 || limit > maxPageSize {
+							limit = maxPageSize
+						}
+						return s.Documents.SearchDocuments(p.Context, v.User.ID, p.Args["query"].(string), limit)
+					},
+				},
+				"sharedWithMe": &graphql.Field{
+					Type:        graphql.NewList(documentType),
+					Description: "get the documents other users have shared with the signed in user that aren't in the trash",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.Documents.FindDocumentsSharedWith(p.Context, v.User.ID)
+					},
+				},
+				"folders": &graphql.Field{
+					Type:        graphql.NewList(folderType),
+					Description: "get all the signed in user's folders, use their parent_id to nest them",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.Folders.FindFoldersByOwner(p.Context, v.User.ID)
+					},
+				},
+				"apiTokens": &graphql.Field{
+					Type:        graphql.NewList(apiTokenType),
+					Description: "get the signed in user's api tokens",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.Credentials.FindAPITokensByUser(p.Context, v.User.ID)
+					},
+				},
+			},
+		},
+	)
+
+	var mutationType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"createDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Create a document.",
+					Args: graphql.FieldConfigArgument{
+						"text": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"author_id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"folder_id": &graphql.ArgumentConfig{
+							Type:        graphql.Int,
+							Description: "One of the author's folders to put the document in.",
+						},
+						"title":       documentArgs["title"],
+						"slug":        documentArgs["slug"],
+						"description": documentArgs["description"],
+						"tags":        documentArgs["tags"],
+						"metadata":    documentArgs["metadata"],
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						if p.Args["author_id"].(int) != v.User.ID {
+							return nil, errForbidden
+						}
+						u := documentUpdateFromArgs(p.Args)
+						d := Document{AuthorID: v.User.ID, FolderID: intArg(p.Args, "folder_id")}
+						u.apply(&d)
+						if d.FolderID != nil {
+							if _, err := s.findOwnFolder(p.Context, d.AuthorID, *d.FolderID); err != nil {
+								return nil, err
+							}
+						}
+						return s.Documents.CreateDocument(p.Context, d)
+					},
+				},
+				"updateDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Update a document.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"expectedVersion": &graphql.ArgumentConfig{
+							Type:        graphql.NewNonNull(graphql.Int),
+							Description: "The version the changes were made to. If the document's been updated since, a VERSION_CONFLICT error with its current_version and current_text is returned.",
+						},
+						"text": &graphql.ArgumentConfig{
+							Type: graphql.String,
+						},
+						"title":       documentArgs["title"],
+						"slug":        documentArgs["slug"],
+						"description": documentArgs["description"],
+						"tags":        documentArgs["tags"],
+						"metadata":    documentArgs["metadata"],
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						update := documentUpdateFromArgs(p.Args)
+						update.ExpectedVersion = p.Args["expectedVersion"].(int)
+						if update.ExpectedVersion < 1 {
+							return nil, errInvalidVersion
+						}
+						if _, err := s.authorizeDocument(p.Context, p.Args["id"].(int), RoleEditor); err != nil {
+							return nil, err
+						}
+						d, err := s.Documents.UpdateDocument(p.Context, p.Args["id"].(int), update)
+						if err != nil {
+							return nil, err
+						}
+						s.events.publish(d)
+						return d, nil
+					},
+				},
+				"deleteDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Move a document to the trash, it can be restored until it's purged.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if _, err := s.authorizeDocument(p.Context, p.Args["id"].(int), RoleOwner); err != nil {
+							return nil, err
+						}
+						return s.Documents.SetDocumentState(p.Context, p.Args["id"].(int), DocumentTrashed)
+					},
+				},
+				"archiveDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Archive a document, hiding it from default listings.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if _, err := s.authorizeDocument(p.Context, p.Args["id"].(int), RoleOwner); err != nil {
+							return nil, err
+						}
+						return s.Documents.SetDocumentState(p.Context, p.Args["id"].(int), DocumentArchived)
+					},
+				},
+				"restoreDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Restore an archived or trashed document.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if _, err := s.authorizeDocument(p.Context, p.Args["id"].(int), RoleOwner); err != nil {
+							return nil, err
+						}
+						return s.Documents.SetDocumentState(p.Context, p.Args["id"].(int), DocumentActive)
+					},
+				},
+				"moveDocument": &graphql.Field{
+					Type:        documentType,
+					Description: "Move one of the signed in user's documents into one of their folders, or out of any folder if folder_id isn't set.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"folder_id": &graphql.ArgumentConfig{
+							Type: graphql.Int,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.moveDocument(p.Context, v.User.ID, p.Args["id"].(int), intArg(p.Args, "folder_id"))
+					},
+				},
+				"shareDocument": &graphql.Field{
+					Type:        documentPermissionType,
+					Description: "Share one of the signed in user's documents with the user with the email, or change the role it's shared with them with. The signed in user must be one of the document's owners.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"email": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"role": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(documentRoleType),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return s.shareDocument(p.Context, p.Args["id"].(int), p.Args["email"].(string), p.Args["role"].(DocumentRole))
+					},
+				},
+				"unshareDocument": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Stop sharing a document with the user with the email. Owners can unshare it with anyone, everyone else can unshare it with themselves.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"email": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if err := s.unshareDocument(p.Context, p.Args["id"].(int), p.Args["email"].(string)); err != nil {
+							return nil, err
+						}
+						return true, nil
+					},
+				},
+				"createShareLink": &graphql.Field{
+					Type:        shareLinkType,
+					Description: "Create a link that shows the document to anyone who has it, e.g. reviewers without an account. The signed in user must be one of the document's owners.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"expires": &graphql.ArgumentConfig{
+							Type:        graphql.String,
+							Description: "When the link stops working, RFC 3339. Links without one work until they're revoked.",
+						},
+						"password": &graphql.ArgumentConfig{
+							Type:        graphql.String,
+							Description: "A password visitors have to enter to see the document.",
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						var expires *time.Time
+						if v, ok := p.Args["expires"].(string); ok {
+							parsed, err := time.Parse(time.RFC3339, v)
+							if err != nil {
+								return nil, errInvalidTime
+							}
+							expires = &parsed
+						}
+						password, _ := p.Args["password"].(string)
+						return s.createShareLink(p.Context, p.Args["id"].(int), expires, password)
+					},
+				},
+				"revokeShareLink": &graphql.Field{
+					Type:        shareLinkType,
+					Description: "Stop a share link showing its document. The signed in user must be one of the document's owners.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return s.revokeShareLink(p.Context, p.Args["id"].(int))
+					},
+				},
+				"createFolder": &graphql.Field{
+					Type:        folderType,
+					Description: "Create a folder for the signed in user in one of their folders, or at the top if parent_id isn't set.",
+					Args: graphql.FieldConfigArgument{
+						"name": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"parent_id": &graphql.ArgumentConfig{
+							Type: graphql.Int,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.createFolder(p.Context, v.User.ID, p.Args["name"].(string), intArg(p.Args, "parent_id"))
+					},
+				},
+				"renameFolder": &graphql.Field{
+					Type:        folderType,
+					Description: "Rename one of the signed in user's folders.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"name": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.renameFolder(p.Context, v.User.ID, p.Args["id"].(int), p.Args["name"].(string))
+					},
+				},
+				"moveFolder": &graphql.Field{
+					Type:        folderType,
+					Description: "Move one of the signed in user's folders into another of their folders, or to the top if parent_id isn't set.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+						"parent_id": &graphql.ArgumentConfig{
+							Type: graphql.Int,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.moveFolder(p.Context, v.User.ID, p.Args["id"].(int), intArg(p.Args, "parent_id"))
+					},
+				},
+				"deleteFolder": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Delete one of the signed in user's folders, the folders and documents in it are moved into its parent.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						if err = s.deleteFolder(p.Context, v.User.ID, p.Args["id"].(int)); err != nil {
+							return nil, err
+						}
+						return true, nil
+					},
+				},
+				"requestEmailChange": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Email a link to confirm changing the signed in user's email to new_email.",
+					Args: graphql.FieldConfigArgument{
+						"new_email": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						if err = s.requestEmailChange(p.Context, v.User, p.Args["new_email"].(string)); err != nil {
+							return nil, err
+						}
+						return true, nil
+					},
+				},
+				"deleteAccount": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Email a link to confirm deleting the signed in user's account and all their data.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						if err = s.requestAccountDeletion(p.Context, v.User); err != nil {
+							return nil, err
+						}
+						return true, nil
+					},
+				},
+				"createAPIToken": &graphql.Field{
+					Type:        apiTokenType,
+					Description: "Create an api token for the signed in user.",
+					Args: graphql.FieldConfigArgument{
+						"name": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.String),
+						},
+						"scope": &graphql.ArgumentConfig{
+							Type:         apiTokenScopeType,
+							DefaultValue: scopeRead,
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.CreateAPIToken(p.Context, v.User.ID, p.Args["name"].(string), p.Args["scope"].(string))
+					},
+				},
+				"revokeAPIToken": &graphql.Field{
+					Type:        apiTokenType,
+					Description: "Revoke one of the signed in user's api tokens.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						return s.Credentials.RevokeAPIToken(p.Context, v.User.ID, p.Args["id"].(int))
+					},
+				},
+			},
+		},
+	)
+
+	var subscriptionType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"documentUpdated": &graphql.Field{
+					Type:        graphql.NewNonNull(documentType),
+					Description: "A document the signed in user can view, each time it's saved, by updateDocument or by a collaborative editing session. Compare its version to yours to skip your own saves.",
+					Args: graphql.FieldConfigArgument{
+						"id": &graphql.ArgumentConfig{
+							Type: graphql.NewNonNull(graphql.Int),
+						},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						v, err := viewerFromContext(p.Context)
+						if err != nil {
+							return nil, err
+						}
+						root, err := subscriptionFromParams(p)
+						if err != nil {
+							return nil, err
+						}
+						if root.document != nil {
+							// it may have been unshared with them since they subscribed.
+							if _, err = s.documentRole(p.Context, v.User.ID, *root.document); err != nil {
+								return nil, err
+							}
+							return *root.document, nil
+						}
+						d, err := s.authorizeDocument(p.Context, p.Args["id"].(int), RoleViewer)
+						if err != nil {
+							return nil, err
+						}
+						root.documentIDs = append(root.documentIDs, d.ID)
+						return d, nil
+					},
+				},
+			},
+		},
+	)
+
+	return graphql.NewSchema(
+		graphql.SchemaConfig{
+			Query:        queryType,
+			Mutation:     mutationType,
+			Subscription: subscriptionType,
+		},
+	)
+}
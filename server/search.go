@@ -0,0 +1,112 @@
+package server
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DocumentMatch is a document found by a search.
+type DocumentMatch struct {
+	Document Document `json:"document"`
+	// Rank is how well the document matches, higher is better.
+	Rank float64 `json:"rank"`
+	// Snippet is HTML of the text around the matches with them wrapped in <mark> tags.
+	Snippet string `json:"snippet"`
+}
+
+// Stores mark matches in snippets with these, markSnippet escapes the rest of the snippet and
+// replaces them with <mark> tags so documents' text can't inject HTML.
+const (
+	snippetStart = "\x01"
+	snippetStop  = "\x02"
+)
+
+// snippetWords is about how many words of text a snippet has.
+const snippetWords = 30
+
+// markSnippet returns the snippet's HTML.
+func markSnippet(snippet string) string {
+	return strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// searchTerms returns the lowercased words in the query.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchDocuments searches the documents for those with every term in their title or text, it's
+// used by stores without full-text search. Documents are ranked by how many times the terms occur.
+func matchDocuments(documents []Document, query string, limit int) []DocumentMatch {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	var matches []DocumentMatch
+	for _, d := range documents {
+		content := strings.ToLower(documentTitle(d) + "\n" + d.Text)
+		count := 0
+		for _, term := range terms {
+			n := strings.Count(content, term)
+			if n == 0 {
+				count = 0
+				break
+			}
+			count += n
+		}
+		if count == 0 {
+			continue
+		}
+		matches = append(matches, DocumentMatch{
+			Document: d,
+			Rank:     float64(count) / float64(len(strings.Fields(content))),
+			Snippet:  markSnippet(snippet(d.Text, terms)),
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Rank > matches[j].Rank })
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// snippet returns the words of the text around the first word with a term, marking the words with
+// terms.
+func snippet(text string, terms []string) string {
+	words := strings.Fields(text)
+	hasTerm := func(word string) bool {
+		word = strings.ToLower(word)
+		for _, term := range terms {
+			if strings.Contains(word, term) {
+				return true
+			}
+		}
+		return false
+	}
+	first := 0
+	for i, word := range words {
+		if hasTerm(word) {
+			first = i
+			break
+		}
+	}
+	start := first - snippetWords/3
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	marked := make([]string, 0, end-start)
+	for _, word := range words[start:end] {
+		if hasTerm(word) {
+			word = snippetStart + word + snippetStop
+		}
+		marked = append(marked, word)
+	}
+	return strings.Join(marked, " ")
+}
@@ -1,35 +1,48 @@
 package server
 
 import (
-	"bytes"
 	"context"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/dgrijalva/jwt-go"
 	uuid "github.com/satori/go.uuid"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/graphql-go/graphql"
-	"github.com/jackc/pgx/v4"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/sendgrid/sendgrid-go"
+	"github.com/travisjeffery/writegood/server/keys"
+	"github.com/travisjeffery/writegood/server/login"
+	"github.com/travisjeffery/writegood/server/mailer"
+	"github.com/travisjeffery/writegood/server/outbox"
+	"github.com/travisjeffery/writegood/server/ratelimit"
+	"github.com/travisjeffery/writegood/server/webauthn"
 )
 
 const userSession = "user_session"
@@ -38,245 +51,381 @@ type User struct {
 	ID       int        `json:"id"`
 	Email    string     `json:"email"`
 	SignedIn *time.Time `json:"signed_in"`
-	Created  time.Time  `json:"signed_in"`
-	Updated  time.Time  `json:"signed_in"`
+	Created  time.Time  `json:"created"`
+	Updated  time.Time  `json:"updated"`
 }
 
 type Document struct {
+	ID          int                    `json:"id"`
+	Text        string                 `json:"text"`
+	AuthorID    int                    `json:"author_id"`
+	Title       string                 `json:"title"`
+	Slug        string                 `json:"slug"`
+	Description string                 `json:"description"`
+	Tags        []string               `json:"tags"`
+	Metadata    map[string]interface{} `json:"metadata"`
+	State       DocumentState          `json:"state"`
+	Trashed     *time.Time             `json:"trashed"`
+	FolderID    *int                   `json:"folder_id"`
+	Version     int                    `json:"version"`
+	Created     time.Time              `json:"created"`
+	Updated     time.Time              `json:"updated"`
+}
+
+type Folder struct {
 	ID       int       `json:"id"`
-	Text     string    `json:"text"`
-	AuthorID int       `json:"author_id"`
+	OwnerID  int       `json:"owner_id"`
+	ParentID *int      `json:"parent_id"`
+	Name     string    `json:"name"`
 	Created  time.Time `json:"created"`
 	Updated  time.Time `json:"updated"`
 }
 
+// DocumentPermission is a role a document's shared with a user with.
+type DocumentPermission struct {
+	DocumentID int          `json:"document_id"`
+	UserID     int          `json:"user_id"`
+	Role       DocumentRole `json:"role"`
+	Created    time.Time    `json:"created"`
+	Updated    time.Time    `json:"updated"`
+}
+
 type Config struct {
-	Connect        string
-	Migrations     string
-	Templates      string
-	VerifyKey      string
-	SignKey        string
-	SendGridAPIKey string
-	Domain         string
-	FromAccount    string
-	FromName       string
-	HashSalt       string
-	SignInExpire   time.Duration
-
-	signKey   *rsa.PrivateKey
-	verifyKey *rsa.PublicKey
+	Connect                string
+	Migrations             string
+	SQLiteMigrations       string
+	Templates              string
+	VerifyKey              string
+	SignKey                string
+	KeysDir                string
+	SendGridAPIKey         string
+	Mailer                 string
+	SMTPAddr               string
+	SMTPUsername           string
+	SMTPPassword           string
+	MailDir                string
+	MailMaxAttempts        int
+	Domain                 string
+	FromAccount            string
+	FromName               string
+	HashSalt               string
+	SignInExpire           time.Duration
+	TrashRetention         time.Duration
+	RateLimiter            string
+	SignInIPLimit          int
+	SignInEmailLimit       int
+	ShareLinkPasswordLimit int
+	TrustProxy             bool
+	TrustedProxies         int
+	SignUp                 bool
+	SignUpDomains          []string
+	OIDCName               string
+	OIDCIssuer             string
+	OIDCClientID           string
+	OIDCClientSecret       string
 }
 
-type Server struct {
-	Config Config
+// redacted replaces secrets in the config when it's logged.
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the config with its secrets replaced so it can be logged.
+func (c Config) Redacted() Config {
+	for _, secret := range []*string{&c.SendGridAPIKey, &c.SMTPPassword, &c.HashSalt, &c.OIDCClientSecret} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	// keep the connect string's host and database, it's a url unless it's postgres' key=value format.
+	u, err := url.Parse(c.Connect)
+	if err == nil && u.Scheme != "" {
+		if q := u.Query(); q.Get("password") != "" {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		c.Connect = u.Redacted()
+	} else if c.Connect != "" {
+		c.Connect = redacted
+	}
+	return c
+}
 
-	conn      *pgx.Conn
+type Server struct {
+	Config      Config
+	Users       UserStore
+	Documents   DocumentStore
+	Folders     FolderStore
+	Credentials CredentialStore
+	Mailer      mailer.Mailer
+
+	db        *pgxpool.Pool
 	router    *mux.Router
 	templates *template.Template
 	sessions  sessions.Store
 	shutdown  chan struct{}
-	email     *sendgrid.Client
 	schema    graphql.Schema
+	collab    *collabHub
+	events    *documentEvents
+
+	signInIPLimiter    ratelimit.Limiter
+	signInEmailLimiter ratelimit.Limiter
+	shareLinkLimiter   ratelimit.Limiter
+	loginProviders     map[string]login.Provider
+	relyingParty       *webauthn.RelyingParty
+	keys               *keys.Keyring
 }
 
 // Run the Server.
 func (s *Server) Run() error {
 	ctx := context.Background()
-	var err error
 
-	s.conn, err = pgx.Connect(ctx, s.Config.Connect)
+	delivery, err := s.newMailer()
 	if err != nil {
-		log.Fatalf("[error] failed to connect to database: %v", err)
+		log.Fatalf("[error] failed to create mailer: %v", err)
 	}
-	defer s.conn.Close(ctx)
 
-	s.email = sendgrid.NewSendClient(s.Config.SendGridAPIKey)
+	if isSQLite(s.Config.Connect) {
+		db, err := OpenSQLite(s.Config.Connect)
+		if err != nil {
+			log.Fatalf("[error] failed to open database: %v", err)
+		}
+		defer db.Close()
+		store := &SQLiteStore{DB: db}
+		s.Users = store
+		s.Documents = store
+		s.Folders = store
+		s.Credentials = store
+		// there's no outbox without postgres so emails are sent as they're made.
+		s.Mailer = delivery
+	} else {
+		// the pool's safe for the handlers and the outbox worker to use concurrently.
+		s.db, err = pgxpool.Connect(ctx, s.Config.Connect)
+		if err != nil {
+			log.Fatalf("[error] failed to connect to database: %v", err)
+		}
+		defer s.db.Close()
+		store := &PostgresStore{DB: s.db}
+		s.Users = store
+		s.Documents = store
+		s.Folders = store
+		s.Credentials = store
+
+		s.Mailer = &outbox.Outbox{DB: s.db}
+		worker := &outbox.Worker{
+			DB:          s.db,
+			Mailer:      delivery,
+			MaxAttempts: s.Config.MailMaxAttempts,
+		}
+		workerCtx, cancelWorker := context.WithCancel(ctx)
+		defer cancelWorker()
+		go worker.Run(workerCtx)
+	}
 
-	signKey, err := ioutil.ReadFile(s.Config.SignKey)
+	if err = s.Init(); err != nil {
+		log.Fatalf("[error] failed to init server: %v", err)
+	}
+
+	purgeCtx, cancelPurge := context.WithCancel(ctx)
+	defer cancelPurge()
+	go s.runTrashPurge(purgeCtx)
+	go s.runRateLimitPrune(purgeCtx)
+
+	s.shutdown = make(chan struct{}, 1)
+	defer func() { <-s.shutdown }()
+
+	log.Printf("running server on :8080")
+	return http.ListenAndServe(":8080", s)
+}
+
+// Init sets up the server to handle requests. The stores and Mailer must be set, Run sets them from
+// the config and calls Init, tests can set them to in-memory ones and call Init instead.
+func (s *Server) Init() error {
+	var err error
+
+	s.signInIPLimiter, err = s.newLimiter(s.Config.SignInIPLimit)
 	if err != nil {
-		log.Fatalf("[error] failed to read sign key file: %v", err)
+		return fmt.Errorf("failed to create rate limiter: %v", err)
 	}
-	s.Config.signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signKey)
+	s.signInEmailLimiter, err = s.newLimiter(s.Config.SignInEmailLimit)
 	if err != nil {
-		log.Fatalf("[error] failed to parse sign key file: %v", err)
+		return fmt.Errorf("failed to create rate limiter: %v", err)
 	}
-	verifyKey, err := ioutil.ReadFile(s.Config.VerifyKey)
+	s.shareLinkLimiter, err = s.newLimiter(s.Config.ShareLinkPasswordLimit)
 	if err != nil {
-		log.Fatalf("[error] failed to read verify key file: %v", err)
+		return fmt.Errorf("failed to create rate limiter: %v", err)
 	}
-	s.Config.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyKey)
+
+	s.loginProviders = make(map[string]login.Provider)
+	if s.Config.OIDCIssuer != "" {
+		s.loginProviders[s.Config.OIDCName] = &login.OIDC{
+			ProviderName: s.Config.OIDCName,
+			Issuer:       s.Config.OIDCIssuer,
+			ClientID:     s.Config.OIDCClientID,
+			ClientSecret: s.Config.OIDCClientSecret,
+			RedirectURL:  s.Config.Domain + "/login/" + s.Config.OIDCName + "/callback",
+		}
+	}
+
+	domain, err := url.Parse(s.Config.Domain)
 	if err != nil {
-		log.Fatalf("[error] failed to parse sign key file: %v", err)
+		return fmt.Errorf("failed to parse domain: %v", err)
+	}
+	s.relyingParty = &webauthn.RelyingParty{
+		ID:     domain.Hostname(),
+		Origin: domain.Scheme + "://" + domain.Host,
 	}
 
-	store := sessions.NewCookieStore(signKey)
+	s.keys, err = s.loadKeys()
+	if err != nil {
+		return fmt.Errorf("failed to load keys: %v", err)
+	}
+	if s.Config.HashSalt == "" && s.Config.KeysDir != "" {
+		s.Config.HashSalt, err = keys.LoadHashSalt(s.Config.KeysDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to load hash salt: %v", err)
+		}
+	}
+	if s.Config.HashSalt == "" {
+		return errors.New("hash salt isn't set, run writegood keygen or set -hash_salt")
+	}
+
+	store := sessions.NewCookieStore(s.keys.SessionKeys...)
 	store.Options.HttpOnly = true
 	store.Options.Secure = !strings.Contains(s.Config.Domain, "localhost")
+	// other sites' requests don't send the session, so they can't act as the user.
+	store.Options.SameSite = http.SameSiteLaxMode
 	s.sessions = store
 
 	templateFiles, err := ioutil.ReadDir(s.Config.Templates)
 	var templateNames []string
 	if err != nil {
-		log.Fatalf("[error] failed to read templates dir: %v", err)
+		return fmt.Errorf("failed to read templates dir: %v", err)
 	}
 	for _, f := range templateFiles {
 		templateNames = append(templateNames, path.Join(s.Config.Templates, f.Name()))
 	}
 	s.templates, err = template.ParseFiles(templateNames...)
 	if err != nil {
-		log.Fatalf("[error] failed to parse templates: %v", err)
-	}
-
-	var documentType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "Document",
-			Fields: graphql.Fields{
-				"id": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.Int),
-				},
-				"text": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.String),
-				},
-				"created": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.String),
-				},
-				"updated": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.String),
-				},
-				"author_id": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.Int),
-				},
-			},
-		},
-	)
+		return fmt.Errorf("failed to parse templates: %v", err)
+	}
 
-	var userType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "User",
-			Fields: graphql.Fields{
-				"id": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.Int),
-				},
-				"email": &graphql.Field{
-					Type: graphql.NewNonNull(graphql.String),
-				},
-
-				"documents": &graphql.Field{
-					Type: graphql.NewList(documentType),
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						return s.FindDocumentsByAuthor(p.Source.(User).ID)
-					},
-				},
-			},
-		},
-	)
+	s.schema, err = s.newSchema()
+	if err != nil {
+		return fmt.Errorf("failed to create schema: %v", err)
+	}
+	s.events = newDocumentEvents()
+	s.collab = newCollabHub(s.events.publish)
+	s.router = s.newRouter()
+	return nil
+}
 
-	var queryType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "Query",
-			Fields: graphql.Fields{
-				"user": &graphql.Field{
-					Type:        userType,
-					Description: "get user",
-					Args: graphql.FieldConfigArgument{
-						"id": &graphql.ArgumentConfig{
-							Type: graphql.Int,
-						},
-						"email": &graphql.ArgumentConfig{
-							Type: graphql.String,
-						},
-					},
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						id, ok := p.Args["id"].(int)
-						if ok {
-							return s.FindUserByID(id)
-						}
-						email, ok := p.Args["email"].(string)
-						if ok {
-							return s.FindUserByEmail(email)
-						}
-						return nil, fmt.Errorf("neither id nor email arg set")
-					},
-				},
-			},
-		},
-	)
+// ServeHTTP serves the request with the server's router.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
 
-	var mutationType = graphql.NewObject(
-		graphql.ObjectConfig{
-			Name: "Mutation",
-			Fields: graphql.Fields{
-				"createUser": &graphql.Field{
-					Type:        userType,
-					Description: "Create a user.",
-					Args: graphql.FieldConfigArgument{
-						"email": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-					},
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						return s.CreateUser(p.Args["email"].(string))
-					},
-				},
-				"createDocument": &graphql.Field{
-					Type:        documentType,
-					Description: "Create a document.",
-					Args: graphql.FieldConfigArgument{
-						"text": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-						"author_id": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.Int),
-						},
-					},
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						return s.CreateDocument(p.Args["author_id"].(int), p.Args["text"].(string))
-					},
-				},
-				"updateDocument": &graphql.Field{
-					Type:        documentType,
-					Description: "Update a document.",
-					Args: graphql.FieldConfigArgument{
-						"text": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.String),
-						},
-						"id": &graphql.ArgumentConfig{
-							Type: graphql.NewNonNull(graphql.Int),
-						},
-					},
-					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-						return s.UpdateDocument(p.Args["id"].(int), p.Args["text"].(string))
-					},
-				},
-			},
-		},
-	)
+// newRouter returns the router for the server's handlers.
+func (s *Server) newRouter() *mux.Router {
+	router := mux.NewRouter()
 
-	s.schema, err = graphql.NewSchema(
-		graphql.SchemaConfig{
-			Query:    queryType,
-			Mutation: mutationType,
-		},
-	)
+	router.PathPrefix("/static").
+		Handler(http.StripPrefix("/static", http.FileServer(http.Dir("dist"))))
+	router.HandleFunc("/graphql", s.HandleGraphqlWS).HeadersRegexp("Upgrade", "(?i)websocket")
+	router.HandleFunc("/graphql", s.HandleGraphql)
+	router.HandleFunc("/sign_in", s.HandleSignIn).Methods("POST")
+	router.HandleFunc("/sign_in/verify", s.HandleSignInVerify)
+	router.HandleFunc("/sign_up/verify", s.HandleSignUpVerify)
+	router.HandleFunc("/email_change/verify", s.HandleEmailChangeVerify)
+	router.HandleFunc("/account/delete/verify", s.HandleDeleteAccountVerify).Methods("GET", "POST")
+	router.HandleFunc("/account/export", s.HandleExportMyData).Methods("GET")
+	router.HandleFunc("/login/{provider}", s.HandleLogin)
+	router.HandleFunc("/login/{provider}/callback", s.HandleLoginCallback)
+	router.HandleFunc("/webauthn/register/begin", s.HandleWebAuthnRegisterBegin).Methods("POST")
+	router.HandleFunc("/webauthn/register/finish", s.HandleWebAuthnRegisterFinish).Methods("POST")
+	router.HandleFunc("/webauthn/sign_in/begin", s.HandleWebAuthnSignInBegin).Methods("POST")
+	router.HandleFunc("/webauthn/sign_in/finish", s.HandleWebAuthnSignInFinish).Methods("POST")
+	router.HandleFunc("/documents/{id:[0-9]+}/collaborate", s.HandleCollaborate)
+	router.HandleFunc("/s/{token}", s.HandleShareLink).Methods("GET", "POST")
+	router.HandleFunc("/sign_out", s.HandleSignOut)
+	router.HandleFunc("/", s.HandleHomepage)
+	return router
+}
+
+// loadKeys loads the keyring from the keys dir, or from the single sign and verify key files if
+// there's no keys dir.
+func (s *Server) loadKeys() (*keys.Keyring, error) {
+	if s.Config.KeysDir != "" {
+		return keys.Load(s.Config.KeysDir)
+	}
+	signKey, err := ioutil.ReadFile(s.Config.SignKey)
 	if err != nil {
-		log.Fatalf("[error] failed to create schema: %v", err)
+		return nil, err
 	}
+	verifyKey, err := ioutil.ReadFile(s.Config.VerifyKey)
+	if err != nil {
+		return nil, err
+	}
+	return keys.FromPEM(signKey, verifyKey)
+}
 
-	s.router = mux.NewRouter()
-
-	s.router.PathPrefix("/static").
-		Handler(http.StripPrefix("/static", http.FileServer(http.Dir("dist"))))
-	s.router.HandleFunc("/graphql", s.HandleGraphql)
-	s.router.HandleFunc("/sign_in", s.HandleSignIn).Methods("POST")
-	s.router.HandleFunc("/sign_in/verify", s.HandleSignInVerify)
-	s.router.HandleFunc("/sign_out", s.HandleSignOut)
-	s.router.HandleFunc("/", s.HandleHomepage)
+// newMailer returns the mailer selected by the config.
+func (s *Server) newMailer() (mailer.Mailer, error) {
+	switch s.Config.Mailer {
+	case "sendgrid", "":
+		return &mailer.SendGrid{Client: sendgrid.NewSendClient(s.Config.SendGridAPIKey)}, nil
+	case "smtp":
+		return &mailer.SMTP{
+			Addr:     s.Config.SMTPAddr,
+			Username: s.Config.SMTPUsername,
+			Password: s.Config.SMTPPassword,
+		}, nil
+	case "file":
+		return &mailer.File{Dir: s.Config.MailDir}, nil
+	case "log":
+		return &mailer.Log{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer: %s", s.Config.Mailer)
+	}
+}
 
-	s.shutdown = make(chan struct{}, 1)
-	defer func() { <-s.shutdown }()
+// newLimiter returns the rate limiter selected by the config allowing perHour actions an hour.
+func (s *Server) newLimiter(perHour int) (ratelimit.Limiter, error) {
+	if perHour < 1 {
+		return nil, fmt.Errorf("rate limit must be at least one an hour: %d", perHour)
+	}
+	every := time.Hour / time.Duration(perHour)
+	switch s.Config.RateLimiter {
+	case "memory", "":
+		return &ratelimit.Memory{Every: every, Burst: perHour}, nil
+	case "postgres":
+		if s.db == nil {
+			return nil, fmt.Errorf("postgres rate limiter: %v", errNeedsPostgres)
+		}
+		return &ratelimit.Postgres{DB: s.db, Every: every, Burst: perHour}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limiter: %s", s.Config.RateLimiter)
+	}
+}
 
-	log.Printf("running server on :8080")
-	return http.ListenAndServe(":8080", s.router)
+// runRateLimitPrune prunes the rate limiters' refilled buckets every purgeInterval until the
+// context is done.
+func (s *Server) runRateLimitPrune(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, limiter := range []ratelimit.Limiter{s.signInIPLimiter, s.signInEmailLimiter, s.shareLinkLimiter} {
+			n, err := limiter.Prune(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("[error] failed to prune rate limits: %v", err)
+			} else if n > 0 {
+				log.Printf("[debug] pruned %d rate limits", n)
+			}
+		}
+	}
 }
 
 func (s *Server) Shutdown() {
@@ -284,7 +433,11 @@ func (s *Server) Shutdown() {
 }
 
 func (s *Server) MustMigrate() {
-	m, err := migrate.New(s.Config.Migrations, s.Config.Connect)
+	migrations := s.Config.Migrations
+	if isSQLite(s.Config.Connect) {
+		migrations = s.Config.SQLiteMigrations
+	}
+	m, err := migrate.New(migrations, s.Config.Connect)
 	if err != nil {
 		log.Fatalf("[error] failed to create migrate instance: %v", err)
 	}
@@ -310,15 +463,52 @@ func (s *Server) generateSignInHash(userID int, signedIn time.Time) string {
 	)
 }
 
+// signToken returns the claims as a jwt signed with the current key.
+func (s *Server) signToken(claims jwt.Claims) (string, error) {
+	kid, key := s.keys.SigningKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(key)
+}
+
+// parseToken verifies the signed jwt and parses it into claims.
+func (s *Server) parseToken(tokenStr string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return s.keys.VerifyKey(kid)
+	})
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
 type jsonQuery struct {
 	Query string `json:"query"`
 }
 
 func (s *Server) HandleHomepage(w http.ResponseWriter, r *http.Request) {
+	var providers []string
+	for name := range s.loginProviders {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
 	data := struct {
-		User *User
+		User           *User
+		SignInSent     bool
+		LoginProviders []string
 	}{
-		User: s.sessionUser(r),
+		User:           s.sessionUser(r),
+		SignInSent:     r.URL.Query().Get("sign_in") == "sent",
+		LoginProviders: providers,
 	}
 	spew.Dump(data)
 	if err := s.templates.Lookup("homepage.html").Execute(w, data); err != nil {
@@ -327,15 +517,18 @@ func (s *Server) HandleHomepage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) HandleGraphql(w http.ResponseWriter, r *http.Request) {
-	// session, err := s.sessions.Get(r, userSession)
-	// if err != nil {
-	// 	log.Printf("[error] failed to get session: %v", err)
-	// }
-	// user := session.Values["user"].(User)
+	viewer, err := s.authenticate(r)
+	if err != nil {
+		log.Printf("[error] failed to authenticate: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
 	query := r.URL.Query().Get("query")
 	// TODO: better way to handle this?
-	if r.Header.Get("Content-Type") == "application/json" {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isJSON := contentType == "application/json"
+	if isJSON {
 		var q jsonQuery
 		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -343,37 +536,91 @@ func (s *Server) HandleGraphql(w http.ResponseWriter, r *http.Request) {
 		}
 		query = q.Query
 	}
+	// forms and links can't send JSON to another site, so mutations can't be forged with them.
+	if hasMutation(query) && (r.Method != http.MethodPost || !isJSON) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(&graphql.Result{
+			Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(errMutationNeedsJSON)},
+		})
+		return
+	}
 
-	// log.Printf("[debug] graphql query for user: %d: query: %s", user, query)
+	ctx := r.Context()
+	if viewer != nil {
+		log.Printf("[debug] graphql query for user: %d: query: %s", viewer.User.ID, query)
+		if viewer.Scope == scopeRead && hasMutation(query) {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(&graphql.Result{
+				Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(errReadOnly)},
+			})
+			return
+		}
+		ctx = withViewer(ctx, viewer)
+	}
 
-	result := s.ExecuteQuery(query, s.schema)
+	result := s.ExecuteQuery(ctx, query, s.schema)
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		log.Printf("[error] failed to encode json: %v", err)
 	}
 }
 
+// hasMutation returns whether the query has a mutation operation.
+func hasMutation(query string) bool {
+	return hasOperation(query, ast.OperationTypeMutation)
+}
+
+// hasOperation returns whether the query has an operation of the type. Queries that fail to parse
+// are left for graphql to report.
+func hasOperation(query, operation string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if ok && op.Operation == operation {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) HandleSignIn(w http.ResponseWriter, r *http.Request) {
 	// send email to log in
 	if err := r.ParseForm(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	email := r.Form.Get("email")
-	user, err := s.FindUserByEmail(email)
+	email := normalizeEmail(r.Form.Get("email"))
+	if !s.allowSignIn(w, r, email) {
+		return
+	}
+	user, err := s.Users.FindUserByEmail(r.Context(), email)
+	if err == ErrNotFound {
+		// respond the same as when the email exists so we don't reveal who has an account.
+		log.Printf("[debug] sign in requested for unknown email: %s", email)
+		if err = s.sendSignUpEmail(r.Context(), email); err != nil {
+			log.Printf("[error] failed to send sign up email: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, signInSentURL, http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		_, _ = io.WriteString(w, "Email not found: "+email)
-		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("[error] failed to find user by email: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	now := time.Now()
-	signedIn, err := s.UpdateUserSignedIn(user.ID, now)
+	signedIn, err := s.Users.UpdateUserSignedIn(r.Context(), user.ID, now)
 	if err != nil {
 		log.Printf("[error] failed to update user signed in: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	hash := s.generateSignInHash(user.ID, signedIn)
-	claims := Claims{
+	signedToken, err := s.signToken(Claims{
 		UserID: user.ID,
 		Hash:   hash,
 		StandardClaims: jwt.StandardClaims{
@@ -382,9 +629,7 @@ func (s *Server) HandleSignIn(w http.ResponseWriter, r *http.Request) {
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(s.Config.SignInExpire).Unix(),
 		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	signedToken, err := token.SignedString(s.Config.signKey)
+	})
 	if err != nil {
 		log.Printf("[error] failed to sign token: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -397,32 +642,65 @@ func (s *Server) HandleSignIn(w http.ResponseWriter, r *http.Request) {
 		Token:  signedToken,
 		Domain: s.Config.Domain,
 	}
-	var plain, html bytes.Buffer
-	if err = s.templates.Lookup("sign_in_plain.html").Execute(&plain, data); err != nil {
-		log.Printf("[error] failed to execute plain email: %v", err)
+	if err = s.sendEmail(r.Context(), email, "Sign in to Write Good", "sign_in", data); err != nil {
+		log.Printf("[error] failed to send email: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if err = s.templates.Lookup("sign_in_html.html").Execute(&html, data); err != nil {
-		log.Printf("[error] failed to execute html email: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	log.Printf("[debug] queued sign in verify email to user: %v", email)
+	http.Redirect(w, r, signInSentURL, http.StatusSeeOther)
+}
+
+// signInSentURL is where sign in requests redirect to whether or not the email has an account.
+const signInSentURL = "/?sign_in=sent"
+
+// allowSignIn checks the sign in rate limits for the client's IP and the email, responding with
+// too many requests if either is exceeded. The email is limited whether or not it has an account.
+func (s *Server) allowSignIn(w http.ResponseWriter, r *http.Request, email string) bool {
+	keys := []struct {
+		limiter ratelimit.Limiter
+		key     string
+	}{
+		{s.signInIPLimiter, "sign_in:ip:" + s.clientIP(r)},
+		{s.signInEmailLimiter, "sign_in:email:" + email},
+	}
+	for _, k := range keys {
+		ok, err := k.limiter.Allow(r.Context(), k.key)
+		if err != nil {
+			log.Printf("[error] failed to check rate limit: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+		if !ok {
+			log.Printf("[info] rate limited sign in: %s", k.key)
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = io.WriteString(w, "Too many sign in attempts, try again later.")
+			return false
+		}
 	}
-	mail := mail.NewSingleEmail(
-		mail.NewEmail(s.Config.FromName, s.Config.FromAccount),
-		"Sign in to Write Good",
-		mail.NewEmail("", email),
-		plain.String(),
-		html.String(),
-	)
-	_, err = s.email.Send(mail)
+	return true
+}
+
+// clientIP returns the IP of the client making the request. Behind proxies it's the address the
+// closest untrusted hop was seen at, the X-Forwarded-For entries before it can be set by the client.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.Config.TrustProxy {
+		var hops []string
+		for _, forwarded := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(forwarded, ",")...)
+		}
+		// each proxy appends the address it got the request from.
+		if i := len(hops) - 1 - s.Config.TrustedProxies; i >= 0 {
+			if ip := strings.TrimSpace(hops[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		log.Printf("[error] failed to send email: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return r.RemoteAddr
 	}
-	log.Printf("[debug] sent sign in verify email to user: %v", email)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return host
 }
 
 func (s *Server) sessionUser(r *http.Request) *User {
@@ -434,46 +712,42 @@ func (s *Server) sessionUser(r *http.Request) *User {
 	if !ok {
 		return nil
 	}
-	user := val.(*User)
-	return user
+	// load the user so sessions of deleted users are signed out and changes show up.
+	user, err := s.Users.FindUserByID(r.Context(), val.(*User).ID)
+	if err != nil {
+		return nil
+	}
+	return &user
 }
 
 func (s *Server) HandleSignInVerify(w http.ResponseWriter, r *http.Request) {
 	// verify sign in
-	tokenStr := r.URL.Query().Get("token")
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return s.Config.verifyKey, nil
-	})
-	claims := token.Claims.(*Claims)
-	user, err := s.FindUserByID(claims.UserID)
+	var claims Claims
+	if err := s.parseToken(r.URL.Query().Get("token"), &claims); err != nil {
+		log.Printf("[error] failed to parse sign in token: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user, err := s.Users.FindUserByID(r.Context(), claims.UserID)
 	if err != nil {
 		log.Printf("[error] failed to find user by id: %d: %v", claims.UserID, err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if claims.Hash != s.generateSignInHash(user.ID, *user.SignedIn) {
+	if user.SignedIn == nil || claims.Hash != s.generateSignInHash(user.ID, *user.SignedIn) {
 		log.Printf("[error] failed to verify claims for user: %d", user.ID)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	log.Printf("[debug] verified sign in of user: %d", user.ID)
 
-	// create session
-	session, err := s.sessions.Get(r, userSession)
-	if err != nil {
-		log.Printf("[error] failed to get session: %v", err)
+	if err = s.startSession(w, r, &user); err != nil {
+		log.Printf("[error] failed to start session: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	session.Values["user"] = &user
-	if err = s.sessions.Save(r, w, session); err != nil {
-		log.Printf("[error] failed to save session: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	log.Printf("[debug] saved session: %d", user.ID)
 
-	signedIn, err := s.UpdateUserSignedIn(user.ID, time.Now())
+	signedIn, err := s.Users.UpdateUserSignedIn(r.Context(), user.ID, time.Now())
 	if err != nil {
 		log.Printf("[error] failed to verify claims for user: %d", user.ID)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -484,6 +758,20 @@ func (s *Server) HandleSignInVerify(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// startSession signs the user in by saving them to the session.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *User) error {
+	session, err := s.sessions.Get(r, userSession)
+	if err != nil {
+		return err
+	}
+	session.Values["user"] = user
+	if err = s.sessions.Save(r, w, session); err != nil {
+		return err
+	}
+	log.Printf("[debug] saved session: %d", user.ID)
+	return nil
+}
+
 func (s *Server) HandleSignOut(w http.ResponseWriter, r *http.Request) {
 	session, err := s.sessions.Get(r, userSession)
 	if err != nil {
@@ -500,90 +788,11 @@ func (s *Server) HandleSignOut(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (s *Server) FindUserByID(id int) (User, error) {
-	log.Printf("[debug] find user with id: %d", id)
-	var user User
-	err := s.conn.
-		QueryRow(context.Background(), `select id, email, created, updated, signed_in from users where id = $1`, id).
-		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
-	return user, err
-}
-
-func (s *Server) FindUserByEmail(email string) (User, error) {
-	log.Printf("[debug] find user with email: %s", email)
-	var user User
-	err := s.conn.
-		QueryRow(context.Background(), `select id, email, created, updated, signed_in from users where email = $1`, email).
-		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
-	return user, err
-}
-
-func (s *Server) CreateUser(email string) (interface{}, error) {
-	log.Printf("[debug] create user with email: %s", email)
-	var user User
-	err := s.conn.
-		QueryRow(context.Background(), `insert into users (email) values ($1) returning id, email, created, updated, signed_in`, email).
-		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
-	return user, err
-}
-
-func (s *Server) CreateDocument(authorID int, text string) (interface{}, error) {
-	log.Printf("[debug] create document with author_id: %d, text: %s", authorID, text)
-	var d Document
-	err := s.conn.
-		QueryRow(context.Background(), `insert into documents (text, author_id) values ($1, $2) returning id, text, author_id`, text, authorID).
-		Scan(&d.ID, &d.Text, &d.AuthorID)
-	return d, err
-}
-
-func (s *Server) UpdateDocument(id int, text string) (interface{}, error) {
-	log.Printf("[debug] update document with id: %d, text: %s", id, text)
-	var d Document
-	err := s.conn.
-		QueryRow(context.Background(), `update documents set text = $1 where id = $2 returning id, text, author_id`, text, id).
-		Scan(&d.ID, &d.Text, &d.AuthorID)
-	return d, err
-}
-
-func (s *Server) UpdateUserSignedIn(id int, signedIn time.Time) (time.Time, error) {
-	log.Printf("[debug] update user with id: %d, signed in: %s", id, signedIn)
-	err := s.conn.
-		QueryRow(context.Background(), `update users set signed_in = $1, updated = $2 where id = $3 returning signed_in`, signedIn, time.Now(), id).
-		Scan(&signedIn)
-	return signedIn, err
-}
-
-func (s *Server) FindDocumentsByAuthor(authorID int) (interface{}, error) {
-	log.Printf("[debug] find documents for author with id: %d", authorID)
-	var documents []Document
-	rows, err := s.conn.Query(context.Background(), `select id, text, author_id from documents where author_id = $1`, authorID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var d Document
-		if err = rows.Scan(&d.ID, &d.Text, &d.AuthorID); err != nil {
-			return nil, err
-		}
-		documents = append(documents, d)
-	}
-	return documents, nil
-}
-
-func (s *Server) FindDocumentByID(id int) (interface{}, error) {
-	log.Printf("[debug] find document with id: %d", id)
-	var document Document
-	err := s.conn.
-		QueryRow(context.Background(), `select id, text, author_id from documents where id = $1`, id).
-		Scan(&document.ID, &document.Text, &document.AuthorID)
-	return document, err
-}
-
-func (s *Server) ExecuteQuery(query string, schema graphql.Schema) *graphql.Result {
+func (s *Server) ExecuteQuery(ctx context.Context, query string, schema graphql.Schema) *graphql.Result {
 	result := graphql.Do(graphql.Params{
 		Schema:        schema,
 		RequestString: query,
+		Context:       ctx,
 	})
 	if len(result.Errors) > 0 {
 		log.Printf("[error] errors: %v", result.Errors)
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shareLinkPrefix makes share link tokens easy to recognize, like apiTokenPrefix.
+const shareLinkPrefix = "wgs_"
+
+// shareLinkSession remembers the password protected share links the visitor has unlocked.
+const shareLinkSession = "share_link_session"
+
+var errExpiresInPast = &graphqlError{code: "EXPIRES_IN_PAST", message: "share links must expire in the future"}
+
+// ShareLink is an unguessable link that shows a document to anyone who has it, e.g. reviewers
+// without an account. Only the token's hash is stored, Token is only set when it's created.
+type ShareLink struct {
+	ID           int        `json:"id"`
+	DocumentID   int        `json:"document_id"`
+	CreatedBy    int        `json:"created_by"`
+	Token        string     `json:"token,omitempty"`
+	Hash         string     `json:"-"`
+	PasswordHash string     `json:"-"`
+	Expires      *time.Time `json:"expires"`
+	Created      time.Time  `json:"created"`
+	Revoked      *time.Time `json:"revoked"`
+}
+
+// active returns whether the link shows its document at the time.
+func (l ShareLink) active(now time.Time) bool {
+	return l.Revoked == nil && (l.Expires == nil || now.Before(*l.Expires))
+}
+
+// shareLinkURL returns the url visitors open the link with.
+func (s *Server) shareLinkURL(token string) string {
+	return s.Config.Domain + "/s/" + token
+}
+
+// createShareLink creates a link to the document, the viewer must be one of its owners. Visitors
+// need the password to open links that have one.
+func (s *Server) createShareLink(ctx context.Context, id int, expires *time.Time, password string) (ShareLink, error) {
+	v, err := viewerFromContext(ctx)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if _, err = s.authorizeDocument(ctx, id, RoleOwner); err != nil {
+		return ShareLink{}, err
+	}
+	if expires != nil && !expires.After(time.Now()) {
+		return ShareLink{}, errExpiresInPast
+	}
+	token, err := generateToken(shareLinkPrefix)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link := ShareLink{DocumentID: id, CreatedBy: v.User.ID, Hash: hashToken(token), Expires: expires}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return ShareLink{}, err
+		}
+		link.PasswordHash = string(hash)
+	}
+	link, err = s.Documents.CreateShareLink(ctx, link)
+	if err != nil {
+		return link, err
+	}
+	link.Token = token
+	return link, nil
+}
+
+// revokeShareLink stops the link showing its document, the viewer must be one of the document's
+// owners.
+func (s *Server) revokeShareLink(ctx context.Context, id int) (ShareLink, error) {
+	link, err := s.Documents.FindShareLinkByID(ctx, id)
+	if err != nil {
+		return link, err
+	}
+	if _, err = s.authorizeDocument(ctx, link.DocumentID, RoleOwner); err != nil {
+		return ShareLink{}, err
+	}
+	return s.Documents.RevokeShareLink(ctx, id)
+}
+
+// findSharedDocument returns the link with the token and its document. Links that are revoked or
+// expired, or whose document is in the trash, aren't found.
+func (s *Server) findSharedDocument(ctx context.Context, token string) (ShareLink, Document, error) {
+	if !strings.HasPrefix(token, shareLinkPrefix) {
+		return ShareLink{}, Document{}, ErrNotFound
+	}
+	link, err := s.Documents.FindShareLinkByHash(ctx, hashToken(token))
+	if err != nil {
+		return link, Document{}, err
+	}
+	if !link.active(time.Now()) {
+		return link, Document{}, ErrNotFound
+	}
+	d, err := s.Documents.FindDocumentByID(ctx, link.DocumentID)
+	if err != nil {
+		return link, d, err
+	}
+	if d.State == DocumentTrashed {
+		return link, d, ErrNotFound
+	}
+	return link, d, nil
+}
+
+// HandleShareLink shows the link's document to anyone who has it. Links with a password ask for it
+// first and remember visitors who got it right for the session.
+func (s *Server) HandleShareLink(w http.ResponseWriter, r *http.Request) {
+	// keep shared drafts out of search engines, and the token out of the referrer of anything the
+	// page loads.
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+
+	link, d, err := s.findSharedDocument(r.Context(), mux.Vars(r)["token"])
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[error] failed to find shared document: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title         string
+		Document      Document
+		NeedsPassword bool
+		WrongPassword bool
+	}{
+		Title:    documentTitle(d),
+		Document: d,
+	}
+
+	if link.PasswordHash != "" {
+		session, err := s.sessions.Get(r, shareLinkSession)
+		if err != nil {
+			log.Printf("[debug] replacing bad share link session: %v", err)
+		}
+		key := fmt.Sprintf("share_link:%d", link.ID)
+		unlocked, _ := session.Values[key].(bool)
+		if !unlocked && r.Method == http.MethodPost {
+			if !s.allowShareLinkPassword(w, r, link) {
+				return
+			}
+			if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(r.PostFormValue("password"))) == nil {
+				session.Values[key] = true
+				if err = s.sessions.Save(r, w, session); err != nil {
+					log.Printf("[error] failed to save session: %v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+				return
+			}
+			log.Printf("[info] wrong password for share link with id: %d", link.ID)
+			data.WrongPassword = true
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		if !unlocked {
+			// don't show anything about the document until they have the password.
+			data.Title = ""
+			data.Document = Document{}
+			data.NeedsPassword = true
+		}
+	}
+
+	if err := s.templates.Lookup("shared_document.html").Execute(w, data); err != nil {
+		log.Printf("[error] failed to execute template: %v", err)
+	}
+}
+
+// allowShareLinkPassword returns whether the client may try another password for the link, it
+// responds itself when they may not.
+func (s *Server) allowShareLinkPassword(w http.ResponseWriter, r *http.Request, link ShareLink) bool {
+	key := fmt.Sprintf("share_link:%d:ip:%s", link.ID, s.clientIP(r))
+	ok, err := s.shareLinkLimiter.Allow(r.Context(), key)
+	if err != nil {
+		log.Printf("[error] failed to check rate limit: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		log.Printf("[info] rate limited share link password: %s", key)
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = io.WriteString(w, "Too many password attempts, try again later.")
+		return false
+	}
+	return true
+}
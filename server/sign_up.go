@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// signUpAudience marks tokens that confirm a sign up so they can't be used for anything else.
+const signUpAudience = "sign_up"
+
+// SignUpClaims are the claims of the token emailed to confirm a sign up. The user isn't created
+// until the token's verified.
+type SignUpClaims struct {
+	Email string
+	jwt.StandardClaims
+}
+
+// sendSignUpEmail emails a sign up confirmation link to the email if sign ups are enabled and the
+// email's domain is allowed. Otherwise it does nothing so the response doesn't differ.
+func (s *Server) sendSignUpEmail(ctx context.Context, email string) error {
+	if !s.canSignUp(email) {
+		log.Printf("[debug] sign up not allowed for email: %s", email)
+		return nil
+	}
+	now := time.Now()
+	signedToken, err := s.signToken(SignUpClaims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  signUpAudience,
+			Issuer:    "Write Good",
+			Id:        uuid.NewV4().String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.Config.SignInExpire).Unix(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Token  string
+		Domain string
+	}{
+		Token:  signedToken,
+		Domain: s.Config.Domain,
+	}
+	if err = s.sendEmail(ctx, email, "Sign up to Write Good", "sign_up", data); err != nil {
+		return err
+	}
+	log.Printf("[debug] queued sign up email to: %s", email)
+	return nil
+}
+
+// canSignUp returns whether the email may be used to sign up.
+func (s *Server) canSignUp(email string) bool {
+	if !s.Config.SignUp {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	if len(s.Config.SignUpDomains) == 0 {
+		return true
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	for _, allowed := range s.Config.SignUpDomains {
+		if domain == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleSignUpVerify creates the user from a sign up confirmation link and signs them in.
+func (s *Server) HandleSignUpVerify(w http.ResponseWriter, r *http.Request) {
+	var claims SignUpClaims
+	if err := s.parseToken(r.URL.Query().Get("token"), &claims); err != nil {
+		log.Printf("[error] failed to parse sign up token: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !claims.VerifyAudience(signUpAudience, true) || !s.canSignUp(claims.Email) {
+		log.Printf("[error] failed to verify sign up claims for email: %s", claims.Email)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the link is only good for creating the user once.
+	_, err := s.Users.FindUserByEmail(r.Context(), claims.Email)
+	if err == nil {
+		log.Printf("[error] sign up for existing email: %s", claims.Email)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != ErrNotFound {
+		log.Printf("[error] failed to find user by email: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.Users.CreateUser(r.Context(), claims.Email)
+	if err != nil {
+		log.Printf("[error] failed to create user: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[debug] signed up user: %d", user.ID)
+
+	signedIn, err := s.Users.UpdateUserSignedIn(r.Context(), user.ID, time.Now())
+	if err != nil {
+		log.Printf("[error] failed to update user signed in: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user.SignedIn = &signedIn
+
+	if err = s.startSession(w, r, &user); err != nil {
+		log.Printf("[error] failed to start session: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/travisjeffery/writegood/server/login"
+)
+
+// ErrNotFound is returned by stores when there's no user or document matching the lookup.
+var ErrNotFound = errors.New("not found")
+
+// UserStore stores users. Emails are normalized and unique, creating or changing to an email
+// another user has returns errEmailTaken.
+type UserStore interface {
+	FindUserByID(ctx context.Context, id int) (User, error)
+	FindUserByEmail(ctx context.Context, email string) (User, error)
+	CreateUser(ctx context.Context, email string) (User, error)
+	UpdateUserSignedIn(ctx context.Context, id int, signedIn time.Time) (time.Time, error)
+	// UpdateUserEmail changes the user's email from oldEmail to newEmail. It returns ErrNotFound
+	// if the user's email isn't oldEmail anymore.
+	UpdateUserEmail(ctx context.Context, id int, oldEmail, newEmail string) (User, error)
+	// DeleteUser deletes the user and everything that's theirs.
+	DeleteUser(ctx context.Context, user User) error
+}
+
+// DocumentStore stores documents.
+type DocumentStore interface {
+	FindDocumentByID(ctx context.Context, id int) (Document, error)
+	// FindDocumentsByAuthor returns the author's documents matching the filter in its sort order.
+	FindDocumentsByAuthor(ctx context.Context, authorID int, filter DocumentFilter) ([]Document, error)
+	// CreateDocument stores the new document, its ID, Created and Updated are set by the store.
+	CreateDocument(ctx context.Context, d Document) (Document, error)
+	// UpdateDocument makes the update and increments the document's version. It returns a
+	// *VersionConflictError if the update's ExpectedVersion isn't the document's version.
+	UpdateDocument(ctx context.Context, id int, update DocumentUpdate) (Document, error)
+	// SetDocumentState moves the document to the state. Moving it to the trash sets Trashed so it's
+	// purged once the retention period is up, moving it out clears it.
+	SetDocumentState(ctx context.Context, id int, state DocumentState) (Document, error)
+	// PurgeDocuments deletes the documents trashed before the time and returns how many there were.
+	PurgeDocuments(ctx context.Context, trashedBefore time.Time) (int, error)
+	// SearchDocuments returns up to limit of the documents the user wrote or that are shared with
+	// them that aren't in the trash matching the query, best matches first.
+	SearchDocuments(ctx context.Context, userID int, query string, limit int) ([]DocumentMatch, error)
+	// MoveDocument moves the document into the folder, or out of any folder if folderID is nil.
+	MoveDocument(ctx context.Context, id int, folderID *int) (Document, error)
+	// ShareDocument gives the user the role on the document, replacing the one they had.
+	ShareDocument(ctx context.Context, documentID, userID int, role DocumentRole) (DocumentPermission, error)
+	// UnshareDocument removes the user's role on the document, or returns ErrNotFound if it isn't
+	// shared with them.
+	UnshareDocument(ctx context.Context, documentID, userID int) error
+	// FindDocumentPermission returns the user's role on the document, or ErrNotFound if it isn't
+	// shared with them.
+	FindDocumentPermission(ctx context.Context, documentID, userID int) (DocumentPermission, error)
+	// FindDocumentPermissions returns who the document's shared with, in the order it was shared.
+	FindDocumentPermissions(ctx context.Context, documentID int) ([]DocumentPermission, error)
+	// FindDocumentsSharedWith returns the documents shared with the user that aren't in the trash,
+	// ordered by id.
+	FindDocumentsSharedWith(ctx context.Context, userID int) ([]Document, error)
+	// CreateShareLink stores the new share link, its ID and Created are set by the store.
+	CreateShareLink(ctx context.Context, link ShareLink) (ShareLink, error)
+	FindShareLinkByID(ctx context.Context, id int) (ShareLink, error)
+	// FindShareLinkByHash returns the share link with the token hash, revoked and expired links
+	// included.
+	FindShareLinkByHash(ctx context.Context, hash string) (ShareLink, error)
+	// FindShareLinks returns the document's share links ordered by id.
+	FindShareLinks(ctx context.Context, documentID int) ([]ShareLink, error)
+	// RevokeShareLink sets the link's Revoked, links that were already revoked keep theirs.
+	RevokeShareLink(ctx context.Context, id int) (ShareLink, error)
+}
+
+// FolderStore stores the folders users organize their documents in. Folders are nested, those
+// without a parent are at the top.
+type FolderStore interface {
+	FindFolderByID(ctx context.Context, id int) (Folder, error)
+	// FindFoldersByOwner returns all the owner's folders ordered by name.
+	FindFoldersByOwner(ctx context.Context, ownerID int) ([]Folder, error)
+	// CreateFolder stores the new folder, its ID, Created and Updated are set by the store.
+	CreateFolder(ctx context.Context, f Folder) (Folder, error)
+	RenameFolder(ctx context.Context, id int, name string) (Folder, error)
+	// MoveFolder moves the folder into the parent, or to the top if parentID is nil. Callers make
+	// sure the parent isn't the folder or in it.
+	MoveFolder(ctx context.Context, id int, parentID *int) (Folder, error)
+	// DeleteFolder deletes the folder, moving its folders and documents into its parent.
+	DeleteFolder(ctx context.Context, id int) error
+}
+
+// CredentialStore stores the ways users sign in and call the API besides email links: API tokens,
+// login provider identities and passkeys. Lookups of ones that don't exist return ErrNotFound.
+type CredentialStore interface {
+	// CreateAPIToken stores the new token, its ID and Created are set by the store.
+	CreateAPIToken(ctx context.Context, t APIToken) (APIToken, error)
+	// FindAPITokensByUser returns the user's tokens ordered by id.
+	FindAPITokensByUser(ctx context.Context, userID int) ([]APIToken, error)
+	// RevokeAPIToken sets the user's token's Revoked, tokens that were already revoked keep theirs.
+	RevokeAPIToken(ctx context.Context, userID, id int) (APIToken, error)
+	// UseAPIToken sets LastUsed of the unrevoked token with the hash and returns it.
+	UseAPIToken(ctx context.Context, hash string) (APIToken, error)
+	// FindUserByIdentity returns the user the provider's identity is linked to.
+	FindUserByIdentity(ctx context.Context, provider, subject string) (User, error)
+	LinkIdentity(ctx context.Context, userID int, identity login.Identity) error
+	FindIdentitiesByUser(ctx context.Context, userID int) ([]UserIdentity, error)
+	// CreateWebAuthnCredential stores the new passkey, its Created is set by the store.
+	CreateWebAuthnCredential(ctx context.Context, c WebAuthnCredential) (WebAuthnCredential, error)
+	FindWebAuthnCredentialsByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error)
+	FindWebAuthnCredential(ctx context.Context, id []byte) (WebAuthnCredential, error)
+	// UpdateWebAuthnCredentialSignCount saves the passkey's sign count and LastUsed after it signs
+	// a challenge made at challenged. The count must increase and the passkey mustn't have been used
+	// since the challenge was made, so concurrent uses of a cloned authenticator or a replayed
+	// challenge can't both succeed. It returns webauthn.ErrSignCount if they don't hold.
+	UpdateWebAuthnCredentialSignCount(ctx context.Context, id []byte, signCount uint32, challenged time.Time) error
+}
+
+// DocumentFilter narrows and orders the documents FindDocumentsByAuthor returns, zero fields match
+// anything.
+type DocumentFilter struct {
+	State DocumentState
+	// FolderID matches documents in the folder, or in no folder if it points to zero.
+	FolderID *int
+	// Tag matches documents tagged with it.
+	Tag           string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Sort orders the documents, by created if it's empty. Documents with the same key are ordered
+	// by id.
+	Sort DocumentSort
+	Desc bool
+	// After returns the documents after the cursor's document in the sort order.
+	After *DocumentCursor
+	// Limit is the most documents to return, no limit if it's zero.
+	Limit int
+}
+
+// DocumentSort is a key documents are sorted by.
+type DocumentSort string
+
+const (
+	SortCreated DocumentSort = "created"
+	SortUpdated DocumentSort = "updated"
+	// SortTitle sorts case-insensitively by documents' titles, or first headings if they weren't
+	// given one.
+	SortTitle DocumentSort = "title"
+)
+
+// DocumentCursor is a document's position in a listing, its id and the key it's sorted by.
+type DocumentCursor struct {
+	ID    int       `json:"id"`
+	Time  time.Time `json:"time,omitempty"`
+	Title string    `json:"title,omitempty"`
+}
+
+// documentSQL builds the query for an author's documents matching a DocumentFilter. The Postgres
+// and SQLite stores differ in the columns they scan and how tags are stored, so they pass those
+// in.
+type documentSQL struct {
+	columns string
+	// tagCondition returns the condition matching documents tagged with the tag at the param.
+	tagCondition func(param string) string
+	// tagArg returns the tag's query arg.
+	tagArg func(tag string) interface{}
+
+	args []interface{}
+}
+
+// sqlSortTitle is the SQL for the key SortTitle sorts by, the heading column is the first heading
+// in the text kept by the stores when the text changes.
+const sqlSortTitle = `lower(coalesce(nullif(title, ''), heading))`
+
+func (q *documentSQL) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// query returns the query and its args.
+func (q *documentSQL) query(authorID int, filter DocumentFilter) (string, []interface{}) {
+	q.args = nil
+	where := []string{"author_id = " + q.arg(authorID)}
+	if filter.State != "" {
+		where = append(where, "state = "+q.arg(string(filter.State)))
+	}
+	if filter.FolderID != nil {
+		if *filter.FolderID == 0 {
+			where = append(where, "folder_id is null")
+		} else {
+			where = append(where, "folder_id = "+q.arg(*filter.FolderID))
+		}
+	}
+	if filter.Tag != "" {
+		where = append(where, q.tagCondition(q.arg(q.tagArg(filter.Tag))))
+	}
+	times := []struct {
+		column string
+		op     string
+		t      time.Time
+	}{
+		{"created", ">", filter.CreatedAfter},
+		{"created", "<", filter.CreatedBefore},
+		{"updated", ">", filter.UpdatedAfter},
+		{"updated", "<", filter.UpdatedBefore},
+	}
+	for _, t := range times {
+		if !t.t.IsZero() {
+			where = append(where, t.column+" "+t.op+" "+q.arg(t.t.UTC()))
+		}
+	}
+
+	key := "created"
+	switch filter.Sort {
+	case SortUpdated:
+		key = "updated"
+	case SortTitle:
+		key = sqlSortTitle
+	}
+	op, dir := ">", "asc"
+	if filter.Desc {
+		op, dir = "<", "desc"
+	}
+	if c := filter.After; c != nil {
+		var after interface{} = c.Time.UTC()
+		if filter.Sort == SortTitle {
+			after = c.Title
+		}
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", key, op, q.arg(after), q.arg(c.ID)))
+	}
+
+	stmt := fmt.Sprintf("select %s from documents where %s order by %s %s, id %s", q.columns, strings.Join(where, " and "), key, dir, dir)
+	if filter.Limit > 0 {
+		stmt += " limit " + q.arg(filter.Limit)
+	}
+	return stmt, q.args
+}
+
+// DocumentUpdate is the changes to make to a document, nil fields are left as they are.
+type DocumentUpdate struct {
+	// ExpectedVersion is the version of the document the changes were made to, the update fails if
+	// it's been changed since. Zero updates whatever the version is.
+	ExpectedVersion int
+	Text            *string
+	Title           *string
+	Slug            *string
+	Description     *string
+	Tags            []string
+	Metadata        map[string]interface{}
+}
+
+// apply makes the update to the document.
+func (u DocumentUpdate) apply(d *Document) {
+	if u.Text != nil {
+		d.Text = *u.Text
+	}
+	if u.Title != nil {
+		d.Title = *u.Title
+	}
+	if u.Slug != nil {
+		d.Slug = *u.Slug
+	}
+	if u.Description != nil {
+		d.Description = *u.Description
+	}
+	if u.Tags != nil {
+		d.Tags = u.Tags
+	}
+	if u.Metadata != nil {
+		d.Metadata = u.Metadata
+	}
+}
+
+// heading returns the first heading of the updated text, or nil if the text isn't changing.
+func (u DocumentUpdate) heading() *string {
+	if u.Text == nil {
+		return nil
+	}
+	heading := firstHeading(*u.Text)
+	return &heading
+}
+
+// jsonParam returns the tags or metadata as JSON for a query param, or nil if they're nil so
+// coalesce leaves the column as it is.
+func jsonParam(v interface{}) (*string, error) {
+	switch t := v.(type) {
+	case []string:
+		if t == nil {
+			return nil, nil
+		}
+	case map[string]interface{}:
+		if t == nil {
+			return nil, nil
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// normalizeEmail returns the email the way it's stored so lookups are case-insensitive.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
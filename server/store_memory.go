@@ -0,0 +1,741 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/travisjeffery/writegood/server/login"
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// MemoryStore is a UserStore, DocumentStore, FolderStore and CredentialStore kept in memory, for
+// tests and trying things out.
+type MemoryStore struct {
+	mu              sync.Mutex
+	users           map[int]User
+	documents       map[int]Document
+	folders         map[int]Folder
+	permissions     map[permissionKey]DocumentPermission
+	shareLinks      map[int]ShareLink
+	apiTokens       map[int]APIToken
+	identities      map[identityKey]UserIdentity
+	credentials     map[string]WebAuthnCredential
+	nextUserID      int
+	nextDocumentID  int
+	nextFolderID    int
+	nextShareLinkID int
+	nextAPITokenID  int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:       make(map[int]User),
+		documents:   make(map[int]Document),
+		folders:     make(map[int]Folder),
+		permissions: make(map[permissionKey]DocumentPermission),
+		shareLinks:  make(map[int]ShareLink),
+		apiTokens:   make(map[int]APIToken),
+		identities:  make(map[identityKey]UserIdentity),
+		credentials: make(map[string]WebAuthnCredential),
+	}
+}
+
+// permissionKey is a document and a user it's shared with.
+type permissionKey struct {
+	documentID int
+	userID     int
+}
+
+// identityKey is a login provider and the subject it identifies a user by.
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+func (m *MemoryStore) FindUserByID(ctx context.Context, id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) FindUserByEmail(ctx context.Context, email string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findUserByEmail(normalizeEmail(email))
+}
+
+func (m *MemoryStore) findUserByEmail(email string) (User, error) {
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, email string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	email = normalizeEmail(email)
+	if _, err := m.findUserByEmail(email); err == nil {
+		return User{}, errEmailTaken
+	}
+	m.nextUserID++
+	now := time.Now()
+	user := User{ID: m.nextUserID, Email: email, Created: now, Updated: now}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *MemoryStore) UpdateUserSignedIn(ctx context.Context, id int, signedIn time.Time) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return signedIn, ErrNotFound
+	}
+	user.SignedIn = &signedIn
+	user.Updated = time.Now()
+	m.users[id] = user
+	return signedIn, nil
+}
+
+func (m *MemoryStore) UpdateUserEmail(ctx context.Context, id int, oldEmail, newEmail string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	newEmail = normalizeEmail(newEmail)
+	user, ok := m.users[id]
+	if !ok || user.Email != oldEmail {
+		return User{}, ErrNotFound
+	}
+	if other, err := m.findUserByEmail(newEmail); err == nil && other.ID != id {
+		return User{}, errEmailTaken
+	}
+	user.Email = newEmail
+	user.Updated = time.Now()
+	m.users[id] = user
+	return user, nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, d := range m.documents {
+		if d.AuthorID == user.ID {
+			delete(m.documents, id)
+		}
+	}
+	for key := range m.permissions {
+		if _, ok := m.documents[key.documentID]; !ok || key.userID == user.ID {
+			delete(m.permissions, key)
+		}
+	}
+	for id, link := range m.shareLinks {
+		if _, ok := m.documents[link.DocumentID]; !ok || link.CreatedBy == user.ID {
+			delete(m.shareLinks, id)
+		}
+	}
+	for id, f := range m.folders {
+		if f.OwnerID == user.ID {
+			delete(m.folders, id)
+		}
+	}
+	for id, t := range m.apiTokens {
+		if t.UserID == user.ID {
+			delete(m.apiTokens, id)
+		}
+	}
+	for key, i := range m.identities {
+		if i.UserID == user.ID {
+			delete(m.identities, key)
+		}
+	}
+	for id, c := range m.credentials {
+		if c.UserID == user.ID {
+			delete(m.credentials, id)
+		}
+	}
+	delete(m.users, user.ID)
+	return nil
+}
+
+func (m *MemoryStore) FindDocumentByID(ctx context.Context, id int) (Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.documents[id]
+	if !ok {
+		return d, ErrNotFound
+	}
+	return d, nil
+}
+
+func (m *MemoryStore) FindDocumentsByAuthor(ctx context.Context, authorID int, filter DocumentFilter) ([]Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var documents []Document
+	for _, d := range m.documents {
+		if d.AuthorID == authorID && matchDocument(d, filter) {
+			documents = append(documents, d)
+		}
+	}
+	less := func(a, b DocumentCursor) bool {
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time) != filter.Desc
+		}
+		if a.Title != b.Title {
+			return (a.Title < b.Title) != filter.Desc
+		}
+		return a.ID != b.ID && (a.ID < b.ID) != filter.Desc
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return less(documentCursor(documents[i], filter.Sort), documentCursor(documents[j], filter.Sort))
+	})
+	if filter.After != nil {
+		i := sort.Search(len(documents), func(i int) bool {
+			return less(*filter.After, documentCursor(documents[i], filter.Sort))
+		})
+		documents = documents[i:]
+	}
+	if filter.Limit > 0 && len(documents) > filter.Limit {
+		documents = documents[:filter.Limit]
+	}
+	return documents, nil
+}
+
+// matchDocument returns whether the document matches the filter's conditions.
+func matchDocument(d Document, filter DocumentFilter) bool {
+	if filter.State != "" && d.State != filter.State {
+		return false
+	}
+	if filter.FolderID != nil {
+		if *filter.FolderID == 0 && d.FolderID != nil || *filter.FolderID != 0 && (d.FolderID == nil || *d.FolderID != *filter.FolderID) {
+			return false
+		}
+	}
+	if filter.Tag != "" {
+		tagged := false
+		for _, tag := range d.Tags {
+			tagged = tagged || tag == filter.Tag
+		}
+		if !tagged {
+			return false
+		}
+	}
+	return (filter.CreatedAfter.IsZero() || d.Created.After(filter.CreatedAfter)) &&
+		(filter.CreatedBefore.IsZero() || d.Created.Before(filter.CreatedBefore)) &&
+		(filter.UpdatedAfter.IsZero() || d.Updated.After(filter.UpdatedAfter)) &&
+		(filter.UpdatedBefore.IsZero() || d.Updated.Before(filter.UpdatedBefore))
+}
+
+func (m *MemoryStore) CreateDocument(ctx context.Context, d Document) (Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[d.AuthorID]; !ok {
+		return Document{}, ErrNotFound
+	}
+	m.nextDocumentID++
+	now := time.Now()
+	d.ID = m.nextDocumentID
+	d.Created = now
+	d.Updated = now
+	if d.Tags == nil {
+		d.Tags = []string{}
+	}
+	if d.Metadata == nil {
+		d.Metadata = map[string]interface{}{}
+	}
+	d.State = DocumentActive
+	d.Trashed = nil
+	d.Version = 1
+	m.documents[d.ID] = d
+	return d, nil
+}
+
+func (m *MemoryStore) UpdateDocument(ctx context.Context, id int, update DocumentUpdate) (Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.documents[id]
+	if !ok {
+		return d, ErrNotFound
+	}
+	if update.ExpectedVersion != 0 && update.ExpectedVersion != d.Version {
+		return d, &VersionConflictError{Current: d}
+	}
+	update.apply(&d)
+	d.Version++
+	d.Updated = time.Now()
+	m.documents[id] = d
+	return d, nil
+}
+
+func (m *MemoryStore) SetDocumentState(ctx context.Context, id int, state DocumentState) (Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.documents[id]
+	if !ok {
+		return d, ErrNotFound
+	}
+	d.State = state
+	d.Trashed = nil
+	if state == DocumentTrashed {
+		now := time.Now()
+		d.Trashed = &now
+	}
+	m.documents[id] = d
+	return d, nil
+}
+
+func (m *MemoryStore) PurgeDocuments(ctx context.Context, trashedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, d := range m.documents {
+		if d.State == DocumentTrashed && d.Trashed.Before(trashedBefore) {
+			delete(m.documents, id)
+			n++
+		}
+	}
+	for key := range m.permissions {
+		if _, ok := m.documents[key.documentID]; !ok {
+			delete(m.permissions, key)
+		}
+	}
+	for id, link := range m.shareLinks {
+		if _, ok := m.documents[link.DocumentID]; !ok {
+			delete(m.shareLinks, id)
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) SearchDocuments(ctx context.Context, userID int, query string, limit int) ([]DocumentMatch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var documents []Document
+	for _, d := range m.documents {
+		_, shared := m.permissions[permissionKey{documentID: d.ID, userID: userID}]
+		if (d.AuthorID == userID || shared) && d.State != DocumentTrashed {
+			documents = append(documents, d)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].ID < documents[j].ID })
+	return matchDocuments(documents, query, limit), nil
+}
+
+func (m *MemoryStore) MoveDocument(ctx context.Context, id int, folderID *int) (Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.documents[id]
+	if !ok {
+		return d, ErrNotFound
+	}
+	if folderID != nil {
+		if _, ok := m.folders[*folderID]; !ok {
+			return d, ErrNotFound
+		}
+	}
+	d.FolderID = folderID
+	m.documents[id] = d
+	return d, nil
+}
+
+func (m *MemoryStore) FindFolderByID(ctx context.Context, id int) (Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return f, ErrNotFound
+	}
+	return f, nil
+}
+
+func (m *MemoryStore) FindFoldersByOwner(ctx context.Context, ownerID int) ([]Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var folders []Folder
+	for _, f := range m.folders {
+		if f.OwnerID == ownerID {
+			folders = append(folders, f)
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return folders[i].ID < folders[j].ID
+	})
+	return folders, nil
+}
+
+func (m *MemoryStore) CreateFolder(ctx context.Context, f Folder) (Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[f.OwnerID]; !ok {
+		return Folder{}, ErrNotFound
+	}
+	if f.ParentID != nil {
+		if _, ok := m.folders[*f.ParentID]; !ok {
+			return Folder{}, ErrNotFound
+		}
+	}
+	m.nextFolderID++
+	now := time.Now()
+	f.ID = m.nextFolderID
+	f.Created = now
+	f.Updated = now
+	m.folders[f.ID] = f
+	return f, nil
+}
+
+func (m *MemoryStore) RenameFolder(ctx context.Context, id int, name string) (Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return f, ErrNotFound
+	}
+	f.Name = name
+	f.Updated = time.Now()
+	m.folders[id] = f
+	return f, nil
+}
+
+func (m *MemoryStore) MoveFolder(ctx context.Context, id int, parentID *int) (Folder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return f, ErrNotFound
+	}
+	if parentID != nil {
+		if _, ok := m.folders[*parentID]; !ok {
+			return f, ErrNotFound
+		}
+	}
+	f.ParentID = parentID
+	f.Updated = time.Now()
+	m.folders[id] = f
+	return f, nil
+}
+
+func (m *MemoryStore) DeleteFolder(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.folders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	for childID, child := range m.folders {
+		if child.ParentID != nil && *child.ParentID == id {
+			child.ParentID = f.ParentID
+			m.folders[childID] = child
+		}
+	}
+	for docID, d := range m.documents {
+		if d.FolderID != nil && *d.FolderID == id {
+			d.FolderID = f.ParentID
+			m.documents[docID] = d
+		}
+	}
+	delete(m.folders, id)
+	return nil
+}
+
+func (m *MemoryStore) ShareDocument(ctx context.Context, documentID, userID int, role DocumentRole) (DocumentPermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.documents[documentID]; !ok {
+		return DocumentPermission{}, ErrNotFound
+	}
+	if _, ok := m.users[userID]; !ok {
+		return DocumentPermission{}, ErrNotFound
+	}
+	key := permissionKey{documentID: documentID, userID: userID}
+	now := time.Now()
+	permission, ok := m.permissions[key]
+	if !ok {
+		permission = DocumentPermission{DocumentID: documentID, UserID: userID, Created: now}
+	}
+	permission.Role = role
+	permission.Updated = now
+	m.permissions[key] = permission
+	return permission, nil
+}
+
+func (m *MemoryStore) UnshareDocument(ctx context.Context, documentID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := permissionKey{documentID: documentID, userID: userID}
+	if _, ok := m.permissions[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.permissions, key)
+	return nil
+}
+
+func (m *MemoryStore) FindDocumentPermission(ctx context.Context, documentID, userID int) (DocumentPermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	permission, ok := m.permissions[permissionKey{documentID: documentID, userID: userID}]
+	if !ok {
+		return permission, ErrNotFound
+	}
+	return permission, nil
+}
+
+func (m *MemoryStore) FindDocumentPermissions(ctx context.Context, documentID int) ([]DocumentPermission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var permissions []DocumentPermission
+	for key, permission := range m.permissions {
+		if key.documentID == documentID {
+			permissions = append(permissions, permission)
+		}
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		a, b := permissions[i], permissions[j]
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.UserID < b.UserID
+	})
+	return permissions, nil
+}
+
+func (m *MemoryStore) FindDocumentsSharedWith(ctx context.Context, userID int) ([]Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var documents []Document
+	for key := range m.permissions {
+		if d, ok := m.documents[key.documentID]; ok && key.userID == userID && d.State != DocumentTrashed {
+			documents = append(documents, d)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].ID < documents[j].ID })
+	return documents, nil
+}
+
+func (m *MemoryStore) CreateShareLink(ctx context.Context, link ShareLink) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.documents[link.DocumentID]; !ok {
+		return ShareLink{}, ErrNotFound
+	}
+	m.nextShareLinkID++
+	link.ID = m.nextShareLinkID
+	link.Created = time.Now()
+	m.shareLinks[link.ID] = link
+	return link, nil
+}
+
+func (m *MemoryStore) FindShareLinkByID(ctx context.Context, id int) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.shareLinks[id]
+	if !ok {
+		return link, ErrNotFound
+	}
+	return link, nil
+}
+
+func (m *MemoryStore) FindShareLinkByHash(ctx context.Context, hash string) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, link := range m.shareLinks {
+		if link.Hash == hash {
+			return link, nil
+		}
+	}
+	return ShareLink{}, ErrNotFound
+}
+
+func (m *MemoryStore) FindShareLinks(ctx context.Context, documentID int) ([]ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var links []ShareLink
+	for _, link := range m.shareLinks {
+		if link.DocumentID == documentID {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+	return links, nil
+}
+
+func (m *MemoryStore) RevokeShareLink(ctx context.Context, id int) (ShareLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.shareLinks[id]
+	if !ok {
+		return link, ErrNotFound
+	}
+	if link.Revoked == nil {
+		now := time.Now()
+		link.Revoked = &now
+		m.shareLinks[id] = link
+	}
+	return link, nil
+}
+
+func (m *MemoryStore) CreateAPIToken(ctx context.Context, t APIToken) (APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[t.UserID]; !ok {
+		return APIToken{}, ErrNotFound
+	}
+	m.nextAPITokenID++
+	t.ID = m.nextAPITokenID
+	t.Token = ""
+	t.Created = time.Now()
+	m.apiTokens[t.ID] = t
+	return t, nil
+}
+
+func (m *MemoryStore) FindAPITokensByUser(ctx context.Context, userID int) ([]APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []APIToken
+	for _, t := range m.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (m *MemoryStore) RevokeAPIToken(ctx context.Context, userID, id int) (APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.apiTokens[id]
+	if !ok || t.UserID != userID {
+		return APIToken{}, ErrNotFound
+	}
+	if t.Revoked == nil {
+		now := time.Now()
+		t.Revoked = &now
+		m.apiTokens[id] = t
+	}
+	return t, nil
+}
+
+func (m *MemoryStore) UseAPIToken(ctx context.Context, hash string) (APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, t := range m.apiTokens {
+		if t.Hash == hash && t.Revoked == nil {
+			now := time.Now()
+			t.LastUsed = &now
+			m.apiTokens[id] = t
+			return t, nil
+		}
+	}
+	return APIToken{}, ErrNotFound
+}
+
+func (m *MemoryStore) FindUserByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.identities[identityKey{provider: provider, subject: subject}]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user, ok := m.users[i.UserID]
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *MemoryStore) LinkIdentity(ctx context.Context, userID int, identity login.Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	key := identityKey{provider: identity.Provider, subject: identity.Subject}
+	if _, ok := m.identities[key]; ok {
+		return fmt.Errorf("identity: %s/%s is already linked", identity.Provider, identity.Subject)
+	}
+	email := identity.Email
+	m.identities[key] = UserIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   userID,
+		Email:    &email,
+		Created:  time.Now(),
+	}
+	return nil
+}
+
+func (m *MemoryStore) FindIdentitiesByUser(ctx context.Context, userID int) ([]UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var identities []UserIdentity
+	for _, i := range m.identities {
+		if i.UserID == userID {
+			identities = append(identities, i)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Created.Before(identities[j].Created) })
+	return identities, nil
+}
+
+func (m *MemoryStore) CreateWebAuthnCredential(ctx context.Context, c WebAuthnCredential) (WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[c.UserID]; !ok {
+		return WebAuthnCredential{}, ErrNotFound
+	}
+	if _, ok := m.credentials[string(c.ID)]; ok {
+		return WebAuthnCredential{}, fmt.Errorf("webauthn credential already exists")
+	}
+	c.Created = time.Now()
+	m.credentials[string(c.ID)] = c
+	return c, nil
+}
+
+func (m *MemoryStore) FindWebAuthnCredentialsByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var creds []WebAuthnCredential
+	for _, c := range m.credentials {
+		if c.UserID == userID {
+			creds = append(creds, c)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Created.Before(creds[j].Created) })
+	return creds, nil
+}
+
+func (m *MemoryStore) FindWebAuthnCredential(ctx context.Context, id []byte) (WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.credentials[string(id)]
+	if !ok {
+		return c, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *MemoryStore) UpdateWebAuthnCredentialSignCount(ctx context.Context, id []byte, signCount uint32, challenged time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.credentials[string(id)]
+	if !ok || (c.SignCount >= signCount && signCount != 0) || (c.LastUsed != nil && !c.LastUsed.Before(challenged)) {
+		return webauthn.ErrSignCount
+	}
+	now := time.Now()
+	c.SignCount = signCount
+	c.LastUsed = &now
+	m.credentials[string(id)] = c
+	return nil
+}
@@ -0,0 +1,714 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/travisjeffery/writegood/server/login"
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// PostgresStore is the UserStore, DocumentStore, FolderStore and CredentialStore backed by Postgres.
+type PostgresStore struct {
+	DB *pgxpool.Pool
+}
+
+// notFound returns ErrNotFound for pgx.ErrNoRows so callers don't depend on pgx.
+func notFound(err error) error {
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *PostgresStore) FindUserByID(ctx context.Context, id int) (User, error) {
+	log.Printf("[debug] find user with id: %d", id)
+	var user User
+	err := p.DB.
+		QueryRow(ctx, `select id, email, created, updated, signed_in from users where id = $1`, id).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, notFound(err)
+}
+
+func (p *PostgresStore) FindUserByEmail(ctx context.Context, email string) (User, error) {
+	email = normalizeEmail(email)
+	log.Printf("[debug] find user with email: %s", email)
+	var user User
+	err := p.DB.
+		QueryRow(ctx, `select id, email, created, updated, signed_in from users where lower(email) = $1`, email).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, notFound(err)
+}
+
+func (p *PostgresStore) CreateUser(ctx context.Context, email string) (User, error) {
+	email = normalizeEmail(email)
+	log.Printf("[debug] create user with email: %s", email)
+	var user User
+	err := p.DB.
+		QueryRow(ctx, `insert into users (email) values ($1) returning id, email, created, updated, signed_in`, email).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	if isUniqueViolation(err) {
+		return user, errEmailTaken
+	}
+	return user, err
+}
+
+func (p *PostgresStore) UpdateUserSignedIn(ctx context.Context, id int, signedIn time.Time) (time.Time, error) {
+	log.Printf("[debug] update user with id: %d, signed in: %s", id, signedIn)
+	err := p.DB.
+		QueryRow(ctx, `update users set signed_in = $1, updated = $2 where id = $3 returning signed_in`, signedIn, time.Now(), id).
+		Scan(&signedIn)
+	return signedIn, notFound(err)
+}
+
+func (p *PostgresStore) UpdateUserEmail(ctx context.Context, id int, oldEmail, newEmail string) (User, error) {
+	newEmail = normalizeEmail(newEmail)
+	log.Printf("[debug] update user with id: %d, email: %s", id, newEmail)
+	var user User
+	err := p.DB.
+		QueryRow(ctx, `update users set email = $1, updated = $2 where id = $3 and email = $4 returning id, email, created, updated, signed_in`, newEmail, time.Now(), id, oldEmail).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	if isUniqueViolation(err) {
+		return user, errEmailTaken
+	}
+	return user, notFound(err)
+}
+
+// DeleteUser deletes the user with their documents, share links, api tokens, linked identities, passkeys, and
+// the emails and rate limits for their address.
+func (p *PostgresStore) DeleteUser(ctx context.Context, user User) error {
+	log.Printf("[debug] delete user with id: %d", user.ID)
+	tx, err := p.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	stmts := []struct {
+		sql string
+		arg interface{}
+	}{
+		{`delete from document_permissions where user_id = $1`, user.ID},
+		{`delete from share_links where created_by = $1`, user.ID},
+		{`delete from documents where author_id = $1`, user.ID},
+		{`delete from folders where owner_id = $1`, user.ID},
+		{`delete from api_tokens where user_id = $1`, user.ID},
+		{`delete from user_identities where user_id = $1`, user.ID},
+		{`delete from webauthn_credentials where user_id = $1`, user.ID},
+		{`delete from email_outbox where lower(to_account) = $1`, user.Email},
+		{`delete from rate_limits where key = $1`, "sign_in:email:" + user.Email},
+		{`delete from users where id = $1`, user.ID},
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.Exec(ctx, stmt.sql, stmt.arg); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// documentColumns are the columns scanDocument scans.
+const documentColumns = `id, text, author_id, title, slug, description, tags, metadata::text, state, trashed, folder_id, version, created, updated`
+
+// scanDocument scans the documentColumns into the document, and any columns selected after them
+// into extra.
+func scanDocument(row pgx.Row, d *Document, extra ...interface{}) error {
+	var metadata string
+	dest := []interface{}{&d.ID, &d.Text, &d.AuthorID, &d.Title, &d.Slug, &d.Description, &d.Tags, &metadata, &d.State, &d.Trashed, &d.FolderID, &d.Version, &d.Created, &d.Updated}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	if d.Tags == nil {
+		d.Tags = []string{}
+	}
+	return json.Unmarshal([]byte(metadata), &d.Metadata)
+}
+
+func (p *PostgresStore) FindDocumentByID(ctx context.Context, id int) (Document, error) {
+	log.Printf("[debug] find document with id: %d", id)
+	var d Document
+	err := scanDocument(p.DB.QueryRow(ctx, `select `+documentColumns+` from documents where id = $1`, id), &d)
+	return d, notFound(err)
+}
+
+func (p *PostgresStore) FindDocumentsByAuthor(ctx context.Context, authorID int, filter DocumentFilter) ([]Document, error) {
+	log.Printf("[debug] find documents for author with id: %d, state: %s", authorID, filter.State)
+	var documents []Document
+	q := &documentSQL{
+		columns: documentColumns,
+		tagCondition: func(param string) string {
+			return "tags @> " + param + "::text[]"
+		},
+		tagArg: func(tag string) interface{} {
+			return []string{tag}
+		},
+	}
+	query, args := q.query(authorID, filter)
+	rows, err := p.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Document
+		if err = scanDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+func (p *PostgresStore) CreateDocument(ctx context.Context, d Document) (Document, error) {
+	log.Printf("[debug] create document with author_id: %d, title: %s", d.AuthorID, d.Title)
+	metadata, err := jsonParam(d.Metadata)
+	if err != nil {
+		return d, err
+	}
+	err = scanDocument(p.DB.QueryRow(
+		ctx,
+		`insert into documents (text, author_id, title, slug, description, tags, metadata, heading, folder_id)
+		values ($1, $2, $3, $4, $5, coalesce($6::text[], '{}'), coalesce($7::jsonb, '{}'), $8, $9)
+		returning `+documentColumns,
+		d.Text,
+		d.AuthorID,
+		d.Title,
+		d.Slug,
+		d.Description,
+		d.Tags,
+		metadata,
+		firstHeading(d.Text),
+		d.FolderID,
+	), &d)
+	return d, err
+}
+
+func (p *PostgresStore) UpdateDocument(ctx context.Context, id int, update DocumentUpdate) (Document, error) {
+	log.Printf("[debug] update document with id: %d", id)
+	var d Document
+	metadata, err := jsonParam(update.Metadata)
+	if err != nil {
+		return d, err
+	}
+	err = scanDocument(p.DB.QueryRow(
+		ctx,
+		`update documents set
+			text = coalesce($1, text),
+			title = coalesce($2, title),
+			slug = coalesce($3, slug),
+			description = coalesce($4, description),
+			tags = coalesce($5, tags),
+			metadata = coalesce($6::jsonb, metadata),
+			heading = coalesce($7, heading),
+			version = version + 1,
+			updated = $8
+		where id = $9 and ($10 = 0 or version = $10)
+		returning `+documentColumns,
+		update.Text,
+		update.Title,
+		update.Slug,
+		update.Description,
+		update.Tags,
+		metadata,
+		update.heading(),
+		time.Now(),
+		id,
+		update.ExpectedVersion,
+	), &d)
+	if err == pgx.ErrNoRows {
+		return Document{}, versionConflict(p.FindDocumentByID(ctx, id))
+	}
+	return d, err
+}
+
+func (p *PostgresStore) SetDocumentState(ctx context.Context, id int, state DocumentState) (Document, error) {
+	log.Printf("[debug] set document with id: %d to state: %s", id, state)
+	var d Document
+	err := scanDocument(p.DB.QueryRow(
+		ctx,
+		`update documents set state = $1, trashed = case when $1 = $2 then $3::timestamptz end
+		where id = $4
+		returning `+documentColumns,
+		string(state),
+		string(DocumentTrashed),
+		time.Now(),
+		id,
+	), &d)
+	return d, notFound(err)
+}
+
+func (p *PostgresStore) PurgeDocuments(ctx context.Context, trashedBefore time.Time) (int, error) {
+	tag, err := p.DB.Exec(ctx, `delete from documents where state = $1 and trashed < $2`, string(DocumentTrashed), trashedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// searchVector is the text search vector of documents' titles and text, it has to match the
+// DOCUMENTS_SEARCH index's expression for the index to be used.
+const searchVector = `to_tsvector('english', title || ' ' || coalesce(text, ''))`
+
+// headlineOptions are the ts_headline options for snippets, matches are marked for markSnippet.
+const headlineOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MinWords=15, MaxWords=35"
+
+func (p *PostgresStore) SearchDocuments(ctx context.Context, userID int, query string, limit int) ([]DocumentMatch, error) {
+	log.Printf("[debug] search documents for user with id: %d", userID)
+	var matches []DocumentMatch
+	rows, err := p.DB.Query(
+		ctx,
+		`select `+documentColumns+`, ts_rank(`+searchVector+`, q) as rank, ts_headline('english', coalesce(text, ''), q, $4)
+		from documents, websearch_to_tsquery('english', $2) q
+		where (author_id = $1 or id in (select document_id from document_permissions where user_id = $1))
+			and state != $3 and `+searchVector+` @@ q
+		order by rank desc, id
+		limit $5`,
+		userID,
+		query,
+		string(DocumentTrashed),
+		headlineOptions,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m DocumentMatch
+		var rank float32
+		if err = scanDocument(rows, &m.Document, &rank, &m.Snippet); err != nil {
+			return nil, err
+		}
+		m.Rank = float64(rank)
+		m.Snippet = markSnippet(m.Snippet)
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+func (p *PostgresStore) MoveDocument(ctx context.Context, id int, folderID *int) (Document, error) {
+	log.Printf("[debug] move document with id: %d", id)
+	var d Document
+	err := scanDocument(p.DB.QueryRow(ctx, `update documents set folder_id = $1 where id = $2 returning `+documentColumns, folderID, id), &d)
+	return d, notFound(err)
+}
+
+const folderColumns = `id, owner_id, parent_id, name, created, updated`
+
+func scanFolder(row pgx.Row, f *Folder) error {
+	return row.Scan(&f.ID, &f.OwnerID, &f.ParentID, &f.Name, &f.Created, &f.Updated)
+}
+
+func (p *PostgresStore) FindFolderByID(ctx context.Context, id int) (Folder, error) {
+	log.Printf("[debug] find folder with id: %d", id)
+	var f Folder
+	err := scanFolder(p.DB.QueryRow(ctx, `select `+folderColumns+` from folders where id = $1`, id), &f)
+	return f, notFound(err)
+}
+
+func (p *PostgresStore) FindFoldersByOwner(ctx context.Context, ownerID int) ([]Folder, error) {
+	log.Printf("[debug] find folders for owner with id: %d", ownerID)
+	var folders []Folder
+	rows, err := p.DB.Query(ctx, `select `+folderColumns+` from folders where owner_id = $1 order by name, id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f Folder
+		if err = scanFolder(rows, &f); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+func (p *PostgresStore) CreateFolder(ctx context.Context, f Folder) (Folder, error) {
+	log.Printf("[debug] create folder with owner_id: %d, name: %s", f.OwnerID, f.Name)
+	err := scanFolder(p.DB.QueryRow(
+		ctx,
+		`insert into folders (owner_id, parent_id, name) values ($1, $2, $3) returning `+folderColumns,
+		f.OwnerID,
+		f.ParentID,
+		f.Name,
+	), &f)
+	return f, err
+}
+
+func (p *PostgresStore) RenameFolder(ctx context.Context, id int, name string) (Folder, error) {
+	log.Printf("[debug] rename folder with id: %d", id)
+	var f Folder
+	err := scanFolder(p.DB.QueryRow(
+		ctx,
+		`update folders set name = $1, updated = $2 where id = $3 returning `+folderColumns,
+		name,
+		time.Now(),
+		id,
+	), &f)
+	return f, notFound(err)
+}
+
+func (p *PostgresStore) MoveFolder(ctx context.Context, id int, parentID *int) (Folder, error) {
+	log.Printf("[debug] move folder with id: %d", id)
+	var f Folder
+	err := scanFolder(p.DB.QueryRow(
+		ctx,
+		`update folders set parent_id = $1, updated = $2 where id = $3 returning `+folderColumns,
+		parentID,
+		time.Now(),
+		id,
+	), &f)
+	return f, notFound(err)
+}
+
+func (p *PostgresStore) DeleteFolder(ctx context.Context, id int) error {
+	log.Printf("[debug] delete folder with id: %d", id)
+	tx, err := p.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	var parentID *int
+	if err = tx.QueryRow(ctx, `select parent_id from folders where id = $1 for update`, id).Scan(&parentID); err != nil {
+		return notFound(err)
+	}
+	if _, err = tx.Exec(ctx, `update folders set parent_id = $1 where parent_id = $2`, parentID, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `update documents set folder_id = $1 where folder_id = $2`, parentID, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `delete from folders where id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+const permissionColumns = `document_id, user_id, role, created, updated`
+
+func scanPermission(row pgx.Row, permission *DocumentPermission) error {
+	return row.Scan(&permission.DocumentID, &permission.UserID, &permission.Role, &permission.Created, &permission.Updated)
+}
+
+func (p *PostgresStore) ShareDocument(ctx context.Context, documentID, userID int, role DocumentRole) (DocumentPermission, error) {
+	log.Printf("[debug] share document with id: %d, with user with id: %d, as: %s", documentID, userID, role)
+	var permission DocumentPermission
+	err := scanPermission(p.DB.QueryRow(
+		ctx,
+		`insert into document_permissions (document_id, user_id, role) values ($1, $2, $3)
+		on conflict (document_id, user_id) do update set role = excluded.role, updated = current_timestamp
+		returning `+permissionColumns,
+		documentID,
+		userID,
+		string(role),
+	), &permission)
+	if isForeignKeyViolation(err) {
+		return permission, ErrNotFound
+	}
+	return permission, err
+}
+
+func (p *PostgresStore) UnshareDocument(ctx context.Context, documentID, userID int) error {
+	log.Printf("[debug] unshare document with id: %d, with user with id: %d", documentID, userID)
+	tag, err := p.DB.Exec(ctx, `delete from document_permissions where document_id = $1 and user_id = $2`, documentID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) FindDocumentPermission(ctx context.Context, documentID, userID int) (DocumentPermission, error) {
+	log.Printf("[debug] find permission on document with id: %d, for user with id: %d", documentID, userID)
+	var permission DocumentPermission
+	err := scanPermission(p.DB.QueryRow(
+		ctx,
+		`select `+permissionColumns+` from document_permissions where document_id = $1 and user_id = $2`,
+		documentID,
+		userID,
+	), &permission)
+	return permission, notFound(err)
+}
+
+func (p *PostgresStore) FindDocumentPermissions(ctx context.Context, documentID int) ([]DocumentPermission, error) {
+	log.Printf("[debug] find permissions on document with id: %d", documentID)
+	var permissions []DocumentPermission
+	rows, err := p.DB.Query(ctx, `select `+permissionColumns+` from document_permissions where document_id = $1 order by created, user_id`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var permission DocumentPermission
+		if err = scanPermission(rows, &permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func (p *PostgresStore) FindDocumentsSharedWith(ctx context.Context, userID int) ([]Document, error) {
+	log.Printf("[debug] find documents shared with user with id: %d", userID)
+	var documents []Document
+	rows, err := p.DB.Query(
+		ctx,
+		`select `+documentColumns+` from documents
+		where id in (select document_id from document_permissions where user_id = $1) and state != $2
+		order by id`,
+		userID,
+		string(DocumentTrashed),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Document
+		if err = scanDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+const shareLinkColumns = `id, document_id, created_by, hash, password_hash, expires, created, revoked`
+
+func scanShareLink(row pgx.Row, link *ShareLink) error {
+	return row.Scan(&link.ID, &link.DocumentID, &link.CreatedBy, &link.Hash, &link.PasswordHash, &link.Expires, &link.Created, &link.Revoked)
+}
+
+func (p *PostgresStore) CreateShareLink(ctx context.Context, link ShareLink) (ShareLink, error) {
+	log.Printf("[debug] create share link for document with id: %d, by user with id: %d", link.DocumentID, link.CreatedBy)
+	var created ShareLink
+	err := scanShareLink(p.DB.QueryRow(
+		ctx,
+		`insert into share_links (document_id, created_by, hash, password_hash, expires) values ($1, $2, $3, $4, $5)
+		returning `+shareLinkColumns,
+		link.DocumentID,
+		link.CreatedBy,
+		link.Hash,
+		link.PasswordHash,
+		link.Expires,
+	), &created)
+	if isForeignKeyViolation(err) {
+		return created, ErrNotFound
+	}
+	return created, err
+}
+
+func (p *PostgresStore) FindShareLinkByID(ctx context.Context, id int) (ShareLink, error) {
+	log.Printf("[debug] find share link with id: %d", id)
+	var link ShareLink
+	err := scanShareLink(p.DB.QueryRow(ctx, `select `+shareLinkColumns+` from share_links where id = $1`, id), &link)
+	return link, notFound(err)
+}
+
+func (p *PostgresStore) FindShareLinkByHash(ctx context.Context, hash string) (ShareLink, error) {
+	var link ShareLink
+	err := scanShareLink(p.DB.QueryRow(ctx, `select `+shareLinkColumns+` from share_links where hash = $1`, hash), &link)
+	return link, notFound(err)
+}
+
+func (p *PostgresStore) FindShareLinks(ctx context.Context, documentID int) ([]ShareLink, error) {
+	log.Printf("[debug] find share links for document with id: %d", documentID)
+	var links []ShareLink
+	rows, err := p.DB.Query(ctx, `select `+shareLinkColumns+` from share_links where document_id = $1 order by id`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link ShareLink
+		if err = scanShareLink(rows, &link); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (p *PostgresStore) RevokeShareLink(ctx context.Context, id int) (ShareLink, error) {
+	log.Printf("[debug] revoke share link with id: %d", id)
+	var link ShareLink
+	err := scanShareLink(p.DB.QueryRow(
+		ctx,
+		`update share_links set revoked = coalesce(revoked, $1) where id = $2 returning `+shareLinkColumns,
+		time.Now(),
+		id,
+	), &link)
+	return link, notFound(err)
+}
+
+const apiTokenColumns = `id, user_id, name, scope, hash, created, last_used, revoked`
+
+func scanAPIToken(row pgx.Row, t *APIToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hash, &t.Created, &t.LastUsed, &t.Revoked)
+}
+
+func (p *PostgresStore) CreateAPIToken(ctx context.Context, t APIToken) (APIToken, error) {
+	log.Printf("[debug] create api token for user with id: %d, name: %s, scope: %s", t.UserID, t.Name, t.Scope)
+	var created APIToken
+	err := scanAPIToken(p.DB.QueryRow(
+		ctx,
+		`insert into api_tokens (user_id, name, hash, scope) values ($1, $2, $3, $4) returning `+apiTokenColumns,
+		t.UserID,
+		t.Name,
+		t.Hash,
+		t.Scope,
+	), &created)
+	return created, err
+}
+
+func (p *PostgresStore) FindAPITokensByUser(ctx context.Context, userID int) ([]APIToken, error) {
+	log.Printf("[debug] find api tokens for user with id: %d", userID)
+	var tokens []APIToken
+	rows, err := p.DB.Query(ctx, `select `+apiTokenColumns+` from api_tokens where user_id = $1 order by id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t APIToken
+		if err = scanAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (p *PostgresStore) RevokeAPIToken(ctx context.Context, userID, id int) (APIToken, error) {
+	log.Printf("[debug] revoke api token with id: %d for user with id: %d", id, userID)
+	var t APIToken
+	err := scanAPIToken(p.DB.QueryRow(
+		ctx,
+		`update api_tokens set revoked = coalesce(revoked, $1) where id = $2 and user_id = $3 returning `+apiTokenColumns,
+		time.Now(),
+		id,
+		userID,
+	), &t)
+	return t, notFound(err)
+}
+
+func (p *PostgresStore) UseAPIToken(ctx context.Context, hash string) (APIToken, error) {
+	var t APIToken
+	err := scanAPIToken(p.DB.QueryRow(
+		ctx,
+		`update api_tokens set last_used = $1 where hash = $2 and revoked is null returning `+apiTokenColumns,
+		time.Now(),
+		hash,
+	), &t)
+	return t, notFound(err)
+}
+
+func (p *PostgresStore) FindUserByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	log.Printf("[debug] find user with identity: %s/%s", provider, subject)
+	var user User
+	err := p.DB.
+		QueryRow(ctx, `select u.id, u.email, u.created, u.updated, u.signed_in from users u join user_identities i on i.user_id = u.id where i.provider = $1 and i.subject = $2`, provider, subject).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, notFound(err)
+}
+
+func (p *PostgresStore) LinkIdentity(ctx context.Context, userID int, identity login.Identity) error {
+	log.Printf("[debug] link identity: %s/%s to user with id: %d", identity.Provider, identity.Subject, userID)
+	_, err := p.DB.Exec(
+		ctx,
+		`insert into user_identities (provider, subject, user_id, email) values ($1, $2, $3, $4)`,
+		identity.Provider,
+		identity.Subject,
+		userID,
+		identity.Email,
+	)
+	return err
+}
+
+func (p *PostgresStore) FindIdentitiesByUser(ctx context.Context, userID int) ([]UserIdentity, error) {
+	log.Printf("[debug] find identities for user with id: %d", userID)
+	var identities []UserIdentity
+	rows, err := p.DB.Query(ctx, `select provider, subject, user_id, email, created from user_identities where user_id = $1 order by created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i UserIdentity
+		if err = rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.Created); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+const webAuthnCredentialColumns = `id, user_id, name, public_key, sign_count, created, last_used`
+
+func scanWebAuthnCredential(row pgx.Row, c *WebAuthnCredential) error {
+	return row.Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+}
+
+func (p *PostgresStore) CreateWebAuthnCredential(ctx context.Context, c WebAuthnCredential) (WebAuthnCredential, error) {
+	log.Printf("[debug] create webauthn credential for user with id: %d, name: %s", c.UserID, c.Name)
+	var created WebAuthnCredential
+	err := scanWebAuthnCredential(p.DB.QueryRow(
+		ctx,
+		`insert into webauthn_credentials (id, user_id, name, public_key, sign_count) values ($1, $2, $3, $4, $5) returning `+webAuthnCredentialColumns,
+		c.ID,
+		c.UserID,
+		c.Name,
+		c.PublicKey,
+		int64(c.SignCount),
+	), &created)
+	return created, err
+}
+
+func (p *PostgresStore) FindWebAuthnCredentialsByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	log.Printf("[debug] find webauthn credentials for user with id: %d", userID)
+	var creds []WebAuthnCredential
+	rows, err := p.DB.Query(ctx, `select `+webAuthnCredentialColumns+` from webauthn_credentials where user_id = $1 order by created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c WebAuthnCredential
+		if err = scanWebAuthnCredential(rows, &c); err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+func (p *PostgresStore) FindWebAuthnCredential(ctx context.Context, id []byte) (WebAuthnCredential, error) {
+	var c WebAuthnCredential
+	err := scanWebAuthnCredential(p.DB.QueryRow(ctx, `select `+webAuthnCredentialColumns+` from webauthn_credentials where id = $1`, id), &c)
+	return c, notFound(err)
+}
+
+func (p *PostgresStore) UpdateWebAuthnCredentialSignCount(ctx context.Context, id []byte, signCount uint32, challenged time.Time) error {
+	tag, err := p.DB.Exec(
+		ctx,
+		`update webauthn_credentials set sign_count = $1, last_used = $2
+		where id = $3 and (sign_count < $1 or $1 = 0) and (last_used is null or last_used < $4)`,
+		int64(signCount),
+		time.Now(),
+		id,
+		challenged,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return webauthn.ErrSignCount
+	}
+	return nil
+}
@@ -0,0 +1,826 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/travisjeffery/writegood/server/login"
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// sqliteScheme is the -connect scheme that selects the SQLite store, e.g. sqlite3://writegood.db.
+const sqliteScheme = "sqlite3://"
+
+// isSQLite returns whether the connect string is for an SQLite database.
+func isSQLite(connect string) bool {
+	return strings.HasPrefix(connect, sqliteScheme)
+}
+
+// OpenSQLite opens the SQLite database the connect string points to. Foreign keys are enforced so
+// the schema cascades deletes the way it does in Postgres.
+func OpenSQLite(connect string) (*sql.DB, error) {
+	dsn := strings.TrimPrefix(connect, sqliteScheme)
+	if strings.Contains(dsn, "?") {
+		dsn += "&_foreign_keys=on"
+	} else {
+		dsn += "?_foreign_keys=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer so share one conn instead of failing with database is locked.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// SQLiteStore is the UserStore, DocumentStore, FolderStore and CredentialStore backed by SQLite, for
+// running writegood without Postgres. SQLite stores times as text, so they're all stored and
+// compared in UTC to sort.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+// sqliteNotFound returns ErrNotFound for sql.ErrNoRows.
+func sqliteNotFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func isSQLiteForeignKeyViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+func (sq *SQLiteStore) FindUserByID(ctx context.Context, id int) (User, error) {
+	log.Printf("[debug] find user with id: %d", id)
+	var user User
+	err := sq.DB.
+		QueryRowContext(ctx, `select id, email, created, updated, signed_in from users where id = $1`, id).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindUserByEmail(ctx context.Context, email string) (User, error) {
+	email = normalizeEmail(email)
+	log.Printf("[debug] find user with email: %s", email)
+	var user User
+	err := sq.DB.
+		QueryRowContext(ctx, `select id, email, created, updated, signed_in from users where lower(email) = $1`, email).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) CreateUser(ctx context.Context, email string) (User, error) {
+	email = normalizeEmail(email)
+	log.Printf("[debug] create user with email: %s", email)
+	now := time.Now().UTC()
+	res, err := sq.DB.ExecContext(ctx, `insert into users (email, created, updated) values ($1, $2, $2)`, email, now)
+	if isSQLiteUniqueViolation(err) {
+		return User{}, errEmailTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return sq.FindUserByID(ctx, int(id))
+}
+
+func (sq *SQLiteStore) UpdateUserSignedIn(ctx context.Context, id int, signedIn time.Time) (time.Time, error) {
+	log.Printf("[debug] update user with id: %d, signed in: %s", id, signedIn)
+	res, err := sq.DB.ExecContext(ctx, `update users set signed_in = $1, updated = $2 where id = $3`, signedIn.UTC(), time.Now().UTC(), id)
+	if err != nil {
+		return signedIn, err
+	}
+	return signedIn, affectedOne(res)
+}
+
+func (sq *SQLiteStore) UpdateUserEmail(ctx context.Context, id int, oldEmail, newEmail string) (User, error) {
+	newEmail = normalizeEmail(newEmail)
+	log.Printf("[debug] update user with id: %d, email: %s", id, newEmail)
+	res, err := sq.DB.ExecContext(ctx, `update users set email = $1, updated = $2 where id = $3 and email = $4`, newEmail, time.Now().UTC(), id, oldEmail)
+	if isSQLiteUniqueViolation(err) {
+		return User{}, errEmailTaken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return User{}, err
+	}
+	return sq.FindUserByID(ctx, id)
+}
+
+// DeleteUser deletes the user and their documents, folders, share links and credentials.
+func (sq *SQLiteStore) DeleteUser(ctx context.Context, user User) error {
+	log.Printf("[debug] delete user with id: %d", user.ID)
+	tx, err := sq.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	stmts := []string{
+		`delete from documents where author_id = $1`,
+		`delete from folders where owner_id = $1`,
+		`delete from api_tokens where user_id = $1`,
+		`delete from user_identities where user_id = $1`,
+		`delete from webauthn_credentials where user_id = $1`,
+		`delete from users where id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt, user.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteDocumentColumns are the columns scanSQLiteDocument scans. Tags and metadata are stored as
+// JSON.
+const sqliteDocumentColumns = `id, text, author_id, title, slug, description, tags, metadata, state, trashed, folder_id, version, created, updated`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteDocument(row scanner, d *Document) error {
+	var tags, metadata string
+	if err := row.Scan(&d.ID, &d.Text, &d.AuthorID, &d.Title, &d.Slug, &d.Description, &tags, &metadata, &d.State, &d.Trashed, &d.FolderID, &d.Version, &d.Created, &d.Updated); err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(tags), &d.Tags); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(metadata), &d.Metadata)
+}
+
+func (sq *SQLiteStore) FindDocumentByID(ctx context.Context, id int) (Document, error) {
+	log.Printf("[debug] find document with id: %d", id)
+	var d Document
+	err := scanSQLiteDocument(sq.DB.QueryRowContext(ctx, `select `+sqliteDocumentColumns+` from documents where id = $1`, id), &d)
+	return d, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindDocumentsByAuthor(ctx context.Context, authorID int, filter DocumentFilter) ([]Document, error) {
+	log.Printf("[debug] find documents for author with id: %d, state: %s", authorID, filter.State)
+	var documents []Document
+	q := &documentSQL{
+		columns: sqliteDocumentColumns,
+		// tags are a JSON array so this matches the JSON string between the array's delimiters,
+		// quotes in tags are escaped so it can't match part of another tag.
+		tagCondition: func(param string) string {
+			return "instr(',' || substr(tags, 2, length(tags) - 2) || ',', ',' || " + param + " || ',') > 0"
+		},
+		tagArg: func(tag string) interface{} {
+			b, _ := json.Marshal(tag)
+			return string(b)
+		},
+	}
+	query, args := q.query(authorID, filter)
+	rows, err := sq.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Document
+		if err = scanSQLiteDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+func (sq *SQLiteStore) CreateDocument(ctx context.Context, d Document) (Document, error) {
+	log.Printf("[debug] create document with author_id: %d, title: %s", d.AuthorID, d.Title)
+	tags, err := jsonParam(d.Tags)
+	if err != nil {
+		return d, err
+	}
+	metadata, err := jsonParam(d.Metadata)
+	if err != nil {
+		return d, err
+	}
+	now := time.Now().UTC()
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`insert into documents (text, author_id, title, slug, description, tags, metadata, heading, folder_id, created, updated)
+		values ($1, $2, $3, $4, $5, coalesce($6, '[]'), coalesce($7, '{}'), $8, $9, $10, $10)`,
+		d.Text,
+		d.AuthorID,
+		d.Title,
+		d.Slug,
+		d.Description,
+		tags,
+		metadata,
+		firstHeading(d.Text),
+		d.FolderID,
+		now,
+	)
+	if err != nil {
+		return d, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return d, err
+	}
+	return sq.FindDocumentByID(ctx, int(id))
+}
+
+func (sq *SQLiteStore) UpdateDocument(ctx context.Context, id int, update DocumentUpdate) (Document, error) {
+	log.Printf("[debug] update document with id: %d", id)
+	tags, err := jsonParam(update.Tags)
+	if err != nil {
+		return Document{}, err
+	}
+	metadata, err := jsonParam(update.Metadata)
+	if err != nil {
+		return Document{}, err
+	}
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`update documents set
+			text = coalesce($1, text),
+			title = coalesce($2, title),
+			slug = coalesce($3, slug),
+			description = coalesce($4, description),
+			tags = coalesce($5, tags),
+			metadata = coalesce($6, metadata),
+			heading = coalesce($7, heading),
+			version = version + 1,
+			updated = $8
+		where id = $9 and ($10 = 0 or version = $10)`,
+		update.Text,
+		update.Title,
+		update.Slug,
+		update.Description,
+		tags,
+		metadata,
+		update.heading(),
+		time.Now().UTC(),
+		id,
+		update.ExpectedVersion,
+	)
+	if err != nil {
+		return Document{}, err
+	}
+	if err = affectedOne(res); err == ErrNotFound {
+		return Document{}, versionConflict(sq.FindDocumentByID(ctx, id))
+	} else if err != nil {
+		return Document{}, err
+	}
+	return sq.FindDocumentByID(ctx, id)
+}
+
+func (sq *SQLiteStore) SetDocumentState(ctx context.Context, id int, state DocumentState) (Document, error) {
+	log.Printf("[debug] set document with id: %d to state: %s", id, state)
+	var trashed *time.Time
+	if state == DocumentTrashed {
+		now := time.Now().UTC()
+		trashed = &now
+	}
+	res, err := sq.DB.ExecContext(ctx, `update documents set state = $1, trashed = $2 where id = $3`, string(state), trashed, id)
+	if err != nil {
+		return Document{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return Document{}, err
+	}
+	return sq.FindDocumentByID(ctx, id)
+}
+
+func (sq *SQLiteStore) PurgeDocuments(ctx context.Context, trashedBefore time.Time) (int, error) {
+	res, err := sq.DB.ExecContext(ctx, `delete from documents where state = $1 and trashed < $2`, string(DocumentTrashed), trashedBefore.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// SearchDocuments finds the documents with every term in the query, there's no full-text search in
+// our SQLite build so they're ranked and snippets are made by matchDocuments.
+func (sq *SQLiteStore) SearchDocuments(ctx context.Context, userID int, query string, limit int) ([]DocumentMatch, error) {
+	log.Printf("[debug] search documents for user with id: %d", userID)
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	where := []string{"(author_id = $1 or id in (select document_id from document_permissions where user_id = $1))", "state != $2"}
+	args := []interface{}{userID, string(DocumentTrashed)}
+	for _, term := range terms {
+		args = append(args, term)
+		where = append(where, fmt.Sprintf("instr(lower(title || ' ' || coalesce(text, '')), $%d) > 0", len(args)))
+	}
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqliteDocumentColumns+` from documents where `+strings.Join(where, " and ")+` order by id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var documents []Document
+	for rows.Next() {
+		var d Document
+		if err = scanSQLiteDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return matchDocuments(documents, query, limit), nil
+}
+
+func (sq *SQLiteStore) MoveDocument(ctx context.Context, id int, folderID *int) (Document, error) {
+	log.Printf("[debug] move document with id: %d", id)
+	res, err := sq.DB.ExecContext(ctx, `update documents set folder_id = $1 where id = $2`, folderID, id)
+	if err != nil {
+		return Document{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return Document{}, err
+	}
+	return sq.FindDocumentByID(ctx, id)
+}
+
+const sqliteFolderColumns = `id, owner_id, parent_id, name, created, updated`
+
+func scanSQLiteFolder(row scanner, f *Folder) error {
+	return row.Scan(&f.ID, &f.OwnerID, &f.ParentID, &f.Name, &f.Created, &f.Updated)
+}
+
+func (sq *SQLiteStore) FindFolderByID(ctx context.Context, id int) (Folder, error) {
+	log.Printf("[debug] find folder with id: %d", id)
+	var f Folder
+	err := scanSQLiteFolder(sq.DB.QueryRowContext(ctx, `select `+sqliteFolderColumns+` from folders where id = $1`, id), &f)
+	return f, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindFoldersByOwner(ctx context.Context, ownerID int) ([]Folder, error) {
+	log.Printf("[debug] find folders for owner with id: %d", ownerID)
+	var folders []Folder
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqliteFolderColumns+` from folders where owner_id = $1 order by name, id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f Folder
+		if err = scanSQLiteFolder(rows, &f); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	return folders, rows.Err()
+}
+
+func (sq *SQLiteStore) CreateFolder(ctx context.Context, f Folder) (Folder, error) {
+	log.Printf("[debug] create folder with owner_id: %d, name: %s", f.OwnerID, f.Name)
+	now := time.Now().UTC()
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`insert into folders (owner_id, parent_id, name, created, updated) values ($1, $2, $3, $4, $4)`,
+		f.OwnerID,
+		f.ParentID,
+		f.Name,
+		now,
+	)
+	if err != nil {
+		return f, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return f, err
+	}
+	return sq.FindFolderByID(ctx, int(id))
+}
+
+func (sq *SQLiteStore) RenameFolder(ctx context.Context, id int, name string) (Folder, error) {
+	log.Printf("[debug] rename folder with id: %d", id)
+	res, err := sq.DB.ExecContext(ctx, `update folders set name = $1, updated = $2 where id = $3`, name, time.Now().UTC(), id)
+	if err != nil {
+		return Folder{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return Folder{}, err
+	}
+	return sq.FindFolderByID(ctx, id)
+}
+
+func (sq *SQLiteStore) MoveFolder(ctx context.Context, id int, parentID *int) (Folder, error) {
+	log.Printf("[debug] move folder with id: %d", id)
+	res, err := sq.DB.ExecContext(ctx, `update folders set parent_id = $1, updated = $2 where id = $3`, parentID, time.Now().UTC(), id)
+	if err != nil {
+		return Folder{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return Folder{}, err
+	}
+	return sq.FindFolderByID(ctx, id)
+}
+
+func (sq *SQLiteStore) DeleteFolder(ctx context.Context, id int) error {
+	log.Printf("[debug] delete folder with id: %d", id)
+	tx, err := sq.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var parentID *int
+	if err = tx.QueryRowContext(ctx, `select parent_id from folders where id = $1`, id).Scan(&parentID); err != nil {
+		return sqliteNotFound(err)
+	}
+	if _, err = tx.ExecContext(ctx, `update folders set parent_id = $1 where parent_id = $2`, parentID, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `update documents set folder_id = $1 where folder_id = $2`, parentID, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `delete from folders where id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const sqlitePermissionColumns = `document_id, user_id, role, created, updated`
+
+func scanSQLitePermission(row scanner, permission *DocumentPermission) error {
+	return row.Scan(&permission.DocumentID, &permission.UserID, &permission.Role, &permission.Created, &permission.Updated)
+}
+
+func (sq *SQLiteStore) ShareDocument(ctx context.Context, documentID, userID int, role DocumentRole) (DocumentPermission, error) {
+	log.Printf("[debug] share document with id: %d, with user with id: %d, as: %s", documentID, userID, role)
+	now := time.Now().UTC()
+	_, err := sq.DB.ExecContext(
+		ctx,
+		`insert into document_permissions (document_id, user_id, role, created, updated) values ($1, $2, $3, $4, $4)
+		on conflict (document_id, user_id) do update set role = excluded.role, updated = excluded.updated`,
+		documentID,
+		userID,
+		string(role),
+		now,
+	)
+	if isSQLiteForeignKeyViolation(err) {
+		return DocumentPermission{}, ErrNotFound
+	}
+	if err != nil {
+		return DocumentPermission{}, err
+	}
+	return sq.FindDocumentPermission(ctx, documentID, userID)
+}
+
+func (sq *SQLiteStore) UnshareDocument(ctx context.Context, documentID, userID int) error {
+	log.Printf("[debug] unshare document with id: %d, with user with id: %d", documentID, userID)
+	res, err := sq.DB.ExecContext(ctx, `delete from document_permissions where document_id = $1 and user_id = $2`, documentID, userID)
+	if err != nil {
+		return err
+	}
+	return affectedOne(res)
+}
+
+func (sq *SQLiteStore) FindDocumentPermission(ctx context.Context, documentID, userID int) (DocumentPermission, error) {
+	log.Printf("[debug] find permission on document with id: %d, for user with id: %d", documentID, userID)
+	var permission DocumentPermission
+	err := scanSQLitePermission(sq.DB.QueryRowContext(
+		ctx,
+		`select `+sqlitePermissionColumns+` from document_permissions where document_id = $1 and user_id = $2`,
+		documentID,
+		userID,
+	), &permission)
+	return permission, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindDocumentPermissions(ctx context.Context, documentID int) ([]DocumentPermission, error) {
+	log.Printf("[debug] find permissions on document with id: %d", documentID)
+	var permissions []DocumentPermission
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqlitePermissionColumns+` from document_permissions where document_id = $1 order by created, user_id`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var permission DocumentPermission
+		if err = scanSQLitePermission(rows, &permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func (sq *SQLiteStore) FindDocumentsSharedWith(ctx context.Context, userID int) ([]Document, error) {
+	log.Printf("[debug] find documents shared with user with id: %d", userID)
+	var documents []Document
+	rows, err := sq.DB.QueryContext(
+		ctx,
+		`select `+sqliteDocumentColumns+` from documents
+		where id in (select document_id from document_permissions where user_id = $1) and state != $2
+		order by id`,
+		userID,
+		string(DocumentTrashed),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Document
+		if err = scanSQLiteDocument(rows, &d); err != nil {
+			return nil, err
+		}
+		documents = append(documents, d)
+	}
+	return documents, rows.Err()
+}
+
+const sqliteShareLinkColumns = `id, document_id, created_by, hash, password_hash, expires, created, revoked`
+
+func scanSQLiteShareLink(row scanner, link *ShareLink) error {
+	return row.Scan(&link.ID, &link.DocumentID, &link.CreatedBy, &link.Hash, &link.PasswordHash, &link.Expires, &link.Created, &link.Revoked)
+}
+
+func (sq *SQLiteStore) CreateShareLink(ctx context.Context, link ShareLink) (ShareLink, error) {
+	log.Printf("[debug] create share link for document with id: %d, by user with id: %d", link.DocumentID, link.CreatedBy)
+	var expires *time.Time
+	if link.Expires != nil {
+		utc := link.Expires.UTC()
+		expires = &utc
+	}
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`insert into share_links (document_id, created_by, hash, password_hash, expires, created) values ($1, $2, $3, $4, $5, $6)`,
+		link.DocumentID,
+		link.CreatedBy,
+		link.Hash,
+		link.PasswordHash,
+		expires,
+		time.Now().UTC(),
+	)
+	if isSQLiteForeignKeyViolation(err) {
+		return ShareLink{}, ErrNotFound
+	}
+	if err != nil {
+		return ShareLink{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return sq.FindShareLinkByID(ctx, int(id))
+}
+
+func (sq *SQLiteStore) FindShareLinkByID(ctx context.Context, id int) (ShareLink, error) {
+	log.Printf("[debug] find share link with id: %d", id)
+	var link ShareLink
+	err := scanSQLiteShareLink(sq.DB.QueryRowContext(ctx, `select `+sqliteShareLinkColumns+` from share_links where id = $1`, id), &link)
+	return link, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindShareLinkByHash(ctx context.Context, hash string) (ShareLink, error) {
+	var link ShareLink
+	err := scanSQLiteShareLink(sq.DB.QueryRowContext(ctx, `select `+sqliteShareLinkColumns+` from share_links where hash = $1`, hash), &link)
+	return link, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindShareLinks(ctx context.Context, documentID int) ([]ShareLink, error) {
+	log.Printf("[debug] find share links for document with id: %d", documentID)
+	var links []ShareLink
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqliteShareLinkColumns+` from share_links where document_id = $1 order by id`, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link ShareLink
+		if err = scanSQLiteShareLink(rows, &link); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (sq *SQLiteStore) RevokeShareLink(ctx context.Context, id int) (ShareLink, error) {
+	log.Printf("[debug] revoke share link with id: %d", id)
+	res, err := sq.DB.ExecContext(ctx, `update share_links set revoked = coalesce(revoked, $1) where id = $2`, time.Now().UTC(), id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return ShareLink{}, err
+	}
+	return sq.FindShareLinkByID(ctx, id)
+}
+
+// affectedOne returns ErrNotFound if the statement didn't change a row.
+func affectedOne(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const sqliteAPITokenColumns = `id, user_id, name, scope, hash, created, last_used, revoked`
+
+func scanSQLiteAPIToken(row scanner, t *APIToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hash, &t.Created, &t.LastUsed, &t.Revoked)
+}
+
+func (sq *SQLiteStore) CreateAPIToken(ctx context.Context, t APIToken) (APIToken, error) {
+	log.Printf("[debug] create api token for user with id: %d, name: %s, scope: %s", t.UserID, t.Name, t.Scope)
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`insert into api_tokens (user_id, name, hash, scope, created) values ($1, $2, $3, $4, $5)`,
+		t.UserID,
+		t.Name,
+		t.Hash,
+		t.Scope,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return APIToken{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return APIToken{}, err
+	}
+	return sq.findAPIToken(ctx, `id = $1`, id)
+}
+
+// findAPIToken returns the token matching the condition on its param.
+func (sq *SQLiteStore) findAPIToken(ctx context.Context, condition string, arg interface{}) (APIToken, error) {
+	var t APIToken
+	err := scanSQLiteAPIToken(sq.DB.QueryRowContext(ctx, `select `+sqliteAPITokenColumns+` from api_tokens where `+condition, arg), &t)
+	return t, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) FindAPITokensByUser(ctx context.Context, userID int) ([]APIToken, error) {
+	log.Printf("[debug] find api tokens for user with id: %d", userID)
+	var tokens []APIToken
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqliteAPITokenColumns+` from api_tokens where user_id = $1 order by id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t APIToken
+		if err = scanSQLiteAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (sq *SQLiteStore) RevokeAPIToken(ctx context.Context, userID, id int) (APIToken, error) {
+	log.Printf("[debug] revoke api token with id: %d for user with id: %d", id, userID)
+	res, err := sq.DB.ExecContext(ctx, `update api_tokens set revoked = coalesce(revoked, $1) where id = $2 and user_id = $3`, time.Now().UTC(), id, userID)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return APIToken{}, err
+	}
+	return sq.findAPIToken(ctx, `id = $1`, id)
+}
+
+func (sq *SQLiteStore) UseAPIToken(ctx context.Context, hash string) (APIToken, error) {
+	res, err := sq.DB.ExecContext(ctx, `update api_tokens set last_used = $1 where hash = $2 and revoked is null`, time.Now().UTC(), hash)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if err = affectedOne(res); err != nil {
+		return APIToken{}, err
+	}
+	return sq.findAPIToken(ctx, `hash = $1`, hash)
+}
+
+func (sq *SQLiteStore) FindUserByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	log.Printf("[debug] find user with identity: %s/%s", provider, subject)
+	var user User
+	err := sq.DB.
+		QueryRowContext(ctx, `select u.id, u.email, u.created, u.updated, u.signed_in from users u join user_identities i on i.user_id = u.id where i.provider = $1 and i.subject = $2`, provider, subject).
+		Scan(&user.ID, &user.Email, &user.Created, &user.Updated, &user.SignedIn)
+	return user, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) LinkIdentity(ctx context.Context, userID int, identity login.Identity) error {
+	log.Printf("[debug] link identity: %s/%s to user with id: %d", identity.Provider, identity.Subject, userID)
+	_, err := sq.DB.ExecContext(
+		ctx,
+		`insert into user_identities (provider, subject, user_id, email, created) values ($1, $2, $3, $4, $5)`,
+		identity.Provider,
+		identity.Subject,
+		userID,
+		identity.Email,
+		time.Now().UTC(),
+	)
+	return err
+}
+
+func (sq *SQLiteStore) FindIdentitiesByUser(ctx context.Context, userID int) ([]UserIdentity, error) {
+	log.Printf("[debug] find identities for user with id: %d", userID)
+	var identities []UserIdentity
+	rows, err := sq.DB.QueryContext(ctx, `select provider, subject, user_id, email, created from user_identities where user_id = $1 order by created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i UserIdentity
+		if err = rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.Created); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+const sqliteWebAuthnCredentialColumns = `id, user_id, name, public_key, sign_count, created, last_used`
+
+func scanSQLiteWebAuthnCredential(row scanner, c *WebAuthnCredential) error {
+	return row.Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+}
+
+func (sq *SQLiteStore) CreateWebAuthnCredential(ctx context.Context, c WebAuthnCredential) (WebAuthnCredential, error) {
+	log.Printf("[debug] create webauthn credential for user with id: %d, name: %s", c.UserID, c.Name)
+	_, err := sq.DB.ExecContext(
+		ctx,
+		`insert into webauthn_credentials (id, user_id, name, public_key, sign_count, created) values ($1, $2, $3, $4, $5, $6)`,
+		c.ID,
+		c.UserID,
+		c.Name,
+		c.PublicKey,
+		int64(c.SignCount),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	return sq.FindWebAuthnCredential(ctx, c.ID)
+}
+
+func (sq *SQLiteStore) FindWebAuthnCredentialsByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	log.Printf("[debug] find webauthn credentials for user with id: %d", userID)
+	var creds []WebAuthnCredential
+	rows, err := sq.DB.QueryContext(ctx, `select `+sqliteWebAuthnCredentialColumns+` from webauthn_credentials where user_id = $1 order by created`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c WebAuthnCredential
+		if err = scanSQLiteWebAuthnCredential(rows, &c); err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+func (sq *SQLiteStore) FindWebAuthnCredential(ctx context.Context, id []byte) (WebAuthnCredential, error) {
+	var c WebAuthnCredential
+	err := scanSQLiteWebAuthnCredential(sq.DB.QueryRowContext(ctx, `select `+sqliteWebAuthnCredentialColumns+` from webauthn_credentials where id = $1`, id), &c)
+	return c, sqliteNotFound(err)
+}
+
+func (sq *SQLiteStore) UpdateWebAuthnCredentialSignCount(ctx context.Context, id []byte, signCount uint32, challenged time.Time) error {
+	res, err := sq.DB.ExecContext(
+		ctx,
+		`update webauthn_credentials set sign_count = $1, last_used = $2
+		where id = $3 and (sign_count < $1 or $1 = 0) and (last_used is null or last_used < $4)`,
+		int64(signCount),
+		time.Now().UTC(),
+		id,
+		challenged.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if err = affectedOne(res); err == ErrNotFound {
+		return webauthn.ErrSignCount
+	}
+	return err
+}
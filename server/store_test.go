@@ -0,0 +1,594 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server"
+	"github.com/travisjeffery/writegood/server/login"
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// postgresConnect is the database TestPostgresStore runs against. Everything in it is dropped.
+var postgresConnect = flag.String("postgres", "", "connect string of a throwaway postgres database to run the postgres store tests against")
+
+type store interface {
+	server.UserStore
+	server.DocumentStore
+	server.FolderStore
+	server.CredentialStore
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, server.NewMemoryStore())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "writegood.db")
+
+	s := &server.Server{Config: server.Config{
+		Connect:          "sqlite3://" + path,
+		SQLiteMigrations: "file://../migrations/sqlite",
+	}}
+	s.MustMigrate()
+
+	db, err := server.OpenSQLite("sqlite3://" + path)
+	require.NoError(t, err)
+	defer db.Close()
+	testStore(t, &server.SQLiteStore{DB: db})
+}
+
+func TestPostgresStore(t *testing.T) {
+	if *postgresConnect == "" {
+		t.Skip("set -postgres to test the postgres store")
+	}
+	ctx := context.Background()
+	m, err := migrate.New("file://../migrations", *postgresConnect)
+	require.NoError(t, err)
+	require.NoError(t, m.Drop())
+	s := &server.Server{Config: server.Config{
+		Connect:    *postgresConnect,
+		Migrations: "file://../migrations",
+	}}
+	s.MustMigrate()
+
+	db, err := pgxpool.Connect(ctx, *postgresConnect)
+	require.NoError(t, err)
+	defer db.Close()
+	testStore(t, &server.PostgresStore{DB: db})
+}
+
+func testStore(t *testing.T, st store) {
+	ctx := context.Background()
+
+	user, err := st.CreateUser(ctx, " Callie@Example.com")
+	require.NoError(t, err)
+	require.Equal(t, "callie@example.com", user.Email)
+	require.Nil(t, user.SignedIn)
+
+	_, err = st.CreateUser(ctx, "CALLIE@example.com")
+	require.EqualError(t, err, "email is already in use")
+
+	found, err := st.FindUserByEmail(ctx, "callie@EXAMPLE.com")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, found.ID)
+
+	_, err = st.FindUserByID(ctx, user.ID+1)
+	require.Equal(t, server.ErrNotFound, err)
+
+	signedIn := time.Now().Truncate(time.Second)
+	_, err = st.UpdateUserSignedIn(ctx, user.ID, signedIn)
+	require.NoError(t, err)
+	found, err = st.FindUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.True(t, signedIn.Equal(*found.SignedIn))
+
+	other, err := st.CreateUser(ctx, "travis@example.com")
+	require.NoError(t, err)
+	_, err = st.UpdateUserEmail(ctx, other.ID, other.Email, user.Email)
+	require.EqualError(t, err, "email is already in use")
+	other, err = st.UpdateUserEmail(ctx, other.ID, other.Email, "Travis@example.org")
+	require.NoError(t, err)
+	require.Equal(t, "travis@example.org", other.Email)
+	// the old email has to match so the change only happens once
+	_, err = st.UpdateUserEmail(ctx, other.ID, "travis@example.com", "travis@example.net")
+	require.Equal(t, server.ErrNotFound, err)
+
+	d, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: "what up homie?"})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, d.AuthorID)
+	require.Equal(t, []string{}, d.Tags)
+	require.Equal(t, map[string]interface{}{}, d.Metadata)
+	require.Equal(t, 1, d.Version)
+	text := "this is different"
+	d, err = st.UpdateDocument(ctx, d.ID, server.DocumentUpdate{Text: &text, ExpectedVersion: 1})
+	require.NoError(t, err)
+	require.Equal(t, "this is different", d.Text)
+	require.Equal(t, 2, d.Version)
+	// updates from an old version conflict and leave the document alone
+	stale := "stale"
+	_, err = st.UpdateDocument(ctx, d.ID, server.DocumentUpdate{Text: &stale, ExpectedVersion: 1})
+	var conflict *server.VersionConflictError
+	require.True(t, errors.As(err, &conflict))
+	require.Equal(t, 2, conflict.Current.Version)
+	require.Equal(t, "this is different", conflict.Current.Text)
+	title := "Different"
+	d, err = st.UpdateDocument(ctx, d.ID, server.DocumentUpdate{
+		Title:    &title,
+		Tags:     []string{"draft", "blog"},
+		Metadata: map[string]interface{}{"audience": "devs"},
+	})
+	require.NoError(t, err)
+	// fields not in the update are left alone
+	require.Equal(t, "this is different", d.Text)
+	require.Equal(t, "Different", d.Title)
+	require.Equal(t, []string{"draft", "blog"}, d.Tags)
+	require.Equal(t, map[string]interface{}{"audience": "devs"}, d.Metadata)
+	require.Equal(t, 3, d.Version)
+	_, err = st.UpdateDocument(ctx, d.ID+1, server.DocumentUpdate{Text: &text})
+	require.Equal(t, server.ErrNotFound, err)
+	_, err = st.UpdateDocument(ctx, d.ID+1, server.DocumentUpdate{Text: &text, ExpectedVersion: 1})
+	require.Equal(t, server.ErrNotFound, err)
+
+	require.Equal(t, server.DocumentActive, d.State)
+
+	documents, err := st.FindDocumentsByAuthor(ctx, user.ID, server.DocumentFilter{})
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	require.Equal(t, d.ID, documents[0].ID)
+
+	archived, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: "old news"})
+	require.NoError(t, err)
+	archived, err = st.SetDocumentState(ctx, archived.ID, server.DocumentArchived)
+	require.NoError(t, err)
+	require.Equal(t, server.DocumentArchived, archived.State)
+	require.Nil(t, archived.Trashed)
+	trashed, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: "bad idea"})
+	require.NoError(t, err)
+	trashed, err = st.SetDocumentState(ctx, trashed.ID, server.DocumentTrashed)
+	require.NoError(t, err)
+	require.Equal(t, server.DocumentTrashed, trashed.State)
+	require.NotNil(t, trashed.Trashed)
+	_, err = st.SetDocumentState(ctx, trashed.ID+1, server.DocumentTrashed)
+	require.Equal(t, server.ErrNotFound, err)
+
+	documents, err = st.FindDocumentsByAuthor(ctx, user.ID, server.DocumentFilter{State: server.DocumentActive})
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	require.Equal(t, d.ID, documents[0].ID)
+	documents, err = st.FindDocumentsByAuthor(ctx, user.ID, server.DocumentFilter{State: server.DocumentTrashed})
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	require.Equal(t, trashed.ID, documents[0].ID)
+
+	// only trash older than the retention is purged
+	n, err := st.PurgeDocuments(ctx, trashed.Trashed.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	n, err = st.PurgeDocuments(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	_, err = st.FindDocumentByID(ctx, trashed.ID)
+	require.Equal(t, server.ErrNotFound, err)
+
+	archived, err = st.SetDocumentState(ctx, archived.ID, server.DocumentActive)
+	require.NoError(t, err)
+	require.Equal(t, server.DocumentActive, archived.State)
+	documents, err = st.FindDocumentsByAuthor(ctx, user.ID, server.DocumentFilter{})
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+
+	require.NoError(t, st.DeleteUser(ctx, user))
+	_, err = st.FindUserByID(ctx, user.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	_, err = st.FindDocumentByID(ctx, d.ID)
+	require.Equal(t, server.ErrNotFound, err)
+
+	testDocumentListing(t, st)
+	testDocumentSearch(t, st)
+	testFolders(t, st)
+	testPermissions(t, st)
+	testShareLinks(t, st)
+	testCredentials(t, st)
+}
+
+func testDocumentListing(t *testing.T, st store) {
+	ctx := context.Background()
+	user, err := st.CreateUser(ctx, "lister@example.com")
+	require.NoError(t, err)
+
+	var ids []int
+	texts := []string{"# Banana", "# apple\n\nhi", "no heading", "# Cherry"}
+	for i, text := range texts {
+		d, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: text, Tags: []string{"all", texts[i%2]}})
+		require.NoError(t, err)
+		ids = append(ids, d.ID)
+	}
+	title := "date"
+	_, err = st.UpdateDocument(ctx, ids[2], server.DocumentUpdate{Title: &title})
+	require.NoError(t, err)
+	text := "# aardvark"
+	_, err = st.UpdateDocument(ctx, ids[3], server.DocumentUpdate{Text: &text})
+	require.NoError(t, err)
+
+	list := func(filter server.DocumentFilter) []int {
+		documents, err := st.FindDocumentsByAuthor(ctx, user.ID, filter)
+		require.NoError(t, err)
+		var got []int
+		for _, d := range documents {
+			got = append(got, d.ID)
+		}
+		return got
+	}
+
+	require.Equal(t, ids, list(server.DocumentFilter{}))
+	require.Equal(t, []int{ids[3], ids[1], ids[0], ids[2]}, list(server.DocumentFilter{Sort: server.SortTitle}))
+	require.Equal(t, []int{ids[2], ids[0], ids[1], ids[3]}, list(server.DocumentFilter{Sort: server.SortTitle, Desc: true}))
+
+	// page through by updated, newest first
+	filter := server.DocumentFilter{Sort: server.SortUpdated, Desc: true, Limit: 3}
+	documents, err := st.FindDocumentsByAuthor(ctx, user.ID, filter)
+	require.NoError(t, err)
+	require.Len(t, documents, 3)
+	require.Equal(t, ids[3], documents[0].ID)
+	require.Equal(t, ids[2], documents[1].ID)
+	last := documents[2]
+	filter.After = &server.DocumentCursor{ID: last.ID, Time: last.Updated}
+	documents, err = st.FindDocumentsByAuthor(ctx, user.ID, filter)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	require.Equal(t, ids[0], documents[0].ID)
+
+	filter = server.DocumentFilter{Sort: server.SortTitle, After: &server.DocumentCursor{ID: ids[1], Title: "apple"}}
+	require.Equal(t, []int{ids[0], ids[2]}, list(filter))
+
+	require.Equal(t, ids, list(server.DocumentFilter{Tag: "all"}))
+	require.Equal(t, []int{ids[1], ids[3]}, list(server.DocumentFilter{Tag: texts[1]}))
+	require.Empty(t, list(server.DocumentFilter{Tag: "al"}))
+
+	first, err := st.FindDocumentByID(ctx, ids[0])
+	require.NoError(t, err)
+	require.Equal(t, ids[1:], list(server.DocumentFilter{CreatedAfter: first.Created}))
+	require.Equal(t, ids[:1], list(server.DocumentFilter{CreatedBefore: first.Created.Add(time.Nanosecond), UpdatedAfter: first.Created.Add(-time.Minute)}))
+	// times in other zones compare as the same instants
+	ahead := time.FixedZone("ahead", 14*60*60)
+	require.Equal(t, ids, list(server.DocumentFilter{CreatedAfter: first.Created.Add(-time.Second).In(ahead)}))
+	require.Equal(t, ids[:1], list(server.DocumentFilter{CreatedBefore: first.Created.Add(time.Nanosecond).In(ahead)}))
+}
+
+func testDocumentSearch(t *testing.T, st store) {
+	ctx := context.Background()
+	user, err := st.CreateUser(ctx, "searcher@example.com")
+	require.NoError(t, err)
+	other, err := st.CreateUser(ctx, "other@example.com")
+	require.NoError(t, err)
+
+	create := func(authorID int, text string) server.Document {
+		d, err := st.CreateDocument(ctx, server.Document{AuthorID: authorID, Text: text})
+		require.NoError(t, err)
+		return d
+	}
+	once := create(user.ID, "# Gardening\n\nTomatoes need <b>sun</b> and water.")
+	twice := create(user.ID, "Tomatoes, tomatoes everywhere and plenty of water.")
+	create(user.ID, "Nothing to see here.")
+	others := create(other.ID, "Other people's tomatoes and water.")
+	trashed := create(user.ID, "Trashed tomatoes and water.")
+	_, err = st.SetDocumentState(ctx, trashed.ID, server.DocumentTrashed)
+	require.NoError(t, err)
+
+	matches, err := st.SearchDocuments(ctx, user.ID, "tomatoes water", 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	require.Equal(t, twice.ID, matches[0].Document.ID)
+	require.Equal(t, once.ID, matches[1].Document.ID)
+	require.True(t, matches[0].Rank > matches[1].Rank)
+	// the text is escaped and only the matches are marked
+	require.Contains(t, matches[1].Snippet, "<mark>Tomatoes</mark>")
+	require.Contains(t, matches[1].Snippet, "&lt;b&gt;sun&lt;/b&gt;")
+
+	matches, err = st.SearchDocuments(ctx, user.ID, "tomatoes", 1)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	matches, err = st.SearchDocuments(ctx, user.ID, "tomatoes cucumbers", 10)
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	// documents shared with them are searched too
+	_, err = st.ShareDocument(ctx, others.ID, user.ID, server.RoleViewer)
+	require.NoError(t, err)
+	matches, err = st.SearchDocuments(ctx, user.ID, "tomatoes water", 10)
+	require.NoError(t, err)
+	require.Len(t, matches, 3)
+	require.Contains(t, []int{matches[0].Document.ID, matches[1].Document.ID, matches[2].Document.ID}, others.ID)
+}
+
+func testFolders(t *testing.T, st store) {
+	ctx := context.Background()
+	user, err := st.CreateUser(ctx, "organizer@example.com")
+	require.NoError(t, err)
+
+	projects, err := st.CreateFolder(ctx, server.Folder{OwnerID: user.ID, Name: "Projects"})
+	require.NoError(t, err)
+	require.Nil(t, projects.ParentID)
+	novel, err := st.CreateFolder(ctx, server.Folder{OwnerID: user.ID, ParentID: &projects.ID, Name: "Novel"})
+	require.NoError(t, err)
+	require.Equal(t, projects.ID, *novel.ParentID)
+	chapters, err := st.CreateFolder(ctx, server.Folder{OwnerID: user.ID, ParentID: &novel.ID, Name: "Chapters"})
+	require.NoError(t, err)
+
+	novel, err = st.RenameFolder(ctx, novel.ID, "A Novel")
+	require.NoError(t, err)
+	require.Equal(t, "A Novel", novel.Name)
+	_, err = st.RenameFolder(ctx, chapters.ID+1, "nope")
+	require.Equal(t, server.ErrNotFound, err)
+
+	folders, err := st.FindFoldersByOwner(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, folders, 3)
+	require.Equal(t, []string{"A Novel", "Chapters", "Projects"}, []string{folders[0].Name, folders[1].Name, folders[2].Name})
+
+	d, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: "Chapter one", FolderID: &chapters.ID})
+	require.NoError(t, err)
+	require.Equal(t, chapters.ID, *d.FolderID)
+	loose, err := st.CreateDocument(ctx, server.Document{AuthorID: user.ID, Text: "Loose notes"})
+	require.NoError(t, err)
+	require.Nil(t, loose.FolderID)
+
+	inFolder := func(folderID int) []int {
+		documents, err := st.FindDocumentsByAuthor(ctx, user.ID, server.DocumentFilter{FolderID: &folderID})
+		require.NoError(t, err)
+		var ids []int
+		for _, d := range documents {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []int{d.ID}, inFolder(chapters.ID))
+	require.Equal(t, []int{loose.ID}, inFolder(0))
+
+	loose, err = st.MoveDocument(ctx, loose.ID, &novel.ID)
+	require.NoError(t, err)
+	require.Equal(t, novel.ID, *loose.FolderID)
+	require.Empty(t, inFolder(0))
+
+	chapters, err = st.MoveFolder(ctx, chapters.ID, nil)
+	require.NoError(t, err)
+	require.Nil(t, chapters.ParentID)
+	chapters, err = st.MoveFolder(ctx, chapters.ID, &novel.ID)
+	require.NoError(t, err)
+	require.Equal(t, novel.ID, *chapters.ParentID)
+
+	// deleting a folder moves what's in it into its parent
+	require.NoError(t, st.DeleteFolder(ctx, novel.ID))
+	_, err = st.FindFolderByID(ctx, novel.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	chapters, err = st.FindFolderByID(ctx, chapters.ID)
+	require.NoError(t, err)
+	require.Equal(t, projects.ID, *chapters.ParentID)
+	require.Equal(t, []int{loose.ID}, inFolder(projects.ID))
+	require.Equal(t, server.ErrNotFound, st.DeleteFolder(ctx, novel.ID))
+
+	require.NoError(t, st.DeleteUser(ctx, user))
+	_, err = st.FindFolderByID(ctx, projects.ID)
+	require.Equal(t, server.ErrNotFound, err)
+}
+
+func testPermissions(t *testing.T, st store) {
+	ctx := context.Background()
+	author, err := st.CreateUser(ctx, "author@example.com")
+	require.NoError(t, err)
+	editor, err := st.CreateUser(ctx, "editor@example.com")
+	require.NoError(t, err)
+	reader, err := st.CreateUser(ctx, "reader@example.com")
+	require.NoError(t, err)
+	draft, err := st.CreateDocument(ctx, server.Document{AuthorID: author.ID, Text: "Draft"})
+	require.NoError(t, err)
+	notes, err := st.CreateDocument(ctx, server.Document{AuthorID: author.ID, Text: "Notes"})
+	require.NoError(t, err)
+
+	_, err = st.FindDocumentPermission(ctx, draft.ID, editor.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	permission, err := st.ShareDocument(ctx, draft.ID, editor.ID, server.RoleViewer)
+	require.NoError(t, err)
+	require.Equal(t, server.RoleViewer, permission.Role)
+	// sharing again changes their role
+	permission, err = st.ShareDocument(ctx, draft.ID, editor.ID, server.RoleEditor)
+	require.NoError(t, err)
+	require.Equal(t, server.RoleEditor, permission.Role)
+	permission, err = st.FindDocumentPermission(ctx, draft.ID, editor.ID)
+	require.NoError(t, err)
+	require.Equal(t, server.RoleEditor, permission.Role)
+	_, err = st.ShareDocument(ctx, draft.ID, reader.ID, server.RoleViewer)
+	require.NoError(t, err)
+	_, err = st.ShareDocument(ctx, notes.ID, editor.ID, server.RoleCommenter)
+	require.NoError(t, err)
+	_, err = st.ShareDocument(ctx, notes.ID+1, editor.ID, server.RoleViewer)
+	require.Equal(t, server.ErrNotFound, err)
+	_, err = st.ShareDocument(ctx, notes.ID, reader.ID+1, server.RoleViewer)
+	require.Equal(t, server.ErrNotFound, err)
+
+	permissions, err := st.FindDocumentPermissions(ctx, draft.ID)
+	require.NoError(t, err)
+	require.Len(t, permissions, 2)
+	require.Equal(t, editor.ID, permissions[0].UserID)
+	require.Equal(t, reader.ID, permissions[1].UserID)
+
+	shared, err := st.FindDocumentsSharedWith(ctx, editor.ID)
+	require.NoError(t, err)
+	require.Len(t, shared, 2)
+	require.Equal(t, draft.ID, shared[0].ID)
+	_, err = st.SetDocumentState(ctx, notes.ID, server.DocumentTrashed)
+	require.NoError(t, err)
+	shared, err = st.FindDocumentsSharedWith(ctx, editor.ID)
+	require.NoError(t, err)
+	require.Len(t, shared, 1)
+
+	require.NoError(t, st.UnshareDocument(ctx, draft.ID, reader.ID))
+	require.Equal(t, server.ErrNotFound, st.UnshareDocument(ctx, draft.ID, reader.ID))
+
+	// purging or deleting the author deletes the permissions
+	_, err = st.PurgeDocuments(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = st.FindDocumentPermission(ctx, notes.ID, editor.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	require.NoError(t, st.DeleteUser(ctx, author))
+	shared, err = st.FindDocumentsSharedWith(ctx, editor.ID)
+	require.NoError(t, err)
+	require.Empty(t, shared)
+	_, err = st.FindDocumentPermission(ctx, draft.ID, editor.ID)
+	require.Equal(t, server.ErrNotFound, err)
+}
+
+func testShareLinks(t *testing.T, st store) {
+	ctx := context.Background()
+	author, err := st.CreateUser(ctx, "linker@example.com")
+	require.NoError(t, err)
+	d, err := st.CreateDocument(ctx, server.Document{AuthorID: author.ID, Text: "Draft"})
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	link, err := st.CreateShareLink(ctx, server.ShareLink{DocumentID: d.ID, CreatedBy: author.ID, Hash: "one", Expires: &expires})
+	require.NoError(t, err)
+	require.NotZero(t, link.ID)
+	require.NotZero(t, link.Created)
+	require.Nil(t, link.Revoked)
+	require.True(t, expires.Equal(*link.Expires))
+	_, err = st.CreateShareLink(ctx, server.ShareLink{DocumentID: d.ID, CreatedBy: author.ID, Hash: "two", PasswordHash: "secret"})
+	require.NoError(t, err)
+	_, err = st.CreateShareLink(ctx, server.ShareLink{DocumentID: d.ID + 1, CreatedBy: author.ID, Hash: "three"})
+	require.Equal(t, server.ErrNotFound, err)
+
+	found, err := st.FindShareLinkByHash(ctx, "two")
+	require.NoError(t, err)
+	require.Equal(t, "secret", found.PasswordHash)
+	require.Nil(t, found.Expires)
+	_, err = st.FindShareLinkByHash(ctx, "three")
+	require.Equal(t, server.ErrNotFound, err)
+
+	revoked, err := st.RevokeShareLink(ctx, link.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.Revoked)
+	// revoking again keeps when it was first revoked
+	again, err := st.RevokeShareLink(ctx, link.ID)
+	require.NoError(t, err)
+	require.True(t, revoked.Revoked.Equal(*again.Revoked))
+	_, err = st.RevokeShareLink(ctx, found.ID+1)
+	require.Equal(t, server.ErrNotFound, err)
+
+	links, err := st.FindShareLinks(ctx, d.ID)
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	require.Equal(t, link.ID, links[0].ID)
+	require.NotNil(t, links[0].Revoked)
+	require.Equal(t, found.ID, links[1].ID)
+
+	// purging the document or deleting the author deletes its links
+	trashed, err := st.CreateDocument(ctx, server.Document{AuthorID: author.ID, Text: "Trashed"})
+	require.NoError(t, err)
+	trashedLink, err := st.CreateShareLink(ctx, server.ShareLink{DocumentID: trashed.ID, CreatedBy: author.ID, Hash: "four"})
+	require.NoError(t, err)
+	_, err = st.SetDocumentState(ctx, trashed.ID, server.DocumentTrashed)
+	require.NoError(t, err)
+	_, err = st.PurgeDocuments(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = st.FindShareLinkByID(ctx, trashedLink.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	require.NoError(t, st.DeleteUser(ctx, author))
+	_, err = st.FindShareLinkByID(ctx, link.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	links, err = st.FindShareLinks(ctx, d.ID)
+	require.NoError(t, err)
+	require.Empty(t, links)
+}
+
+func testCredentials(t *testing.T, st store) {
+	ctx := context.Background()
+	user, err := st.CreateUser(ctx, "keys@example.com")
+	require.NoError(t, err)
+
+	token, err := st.CreateAPIToken(ctx, server.APIToken{UserID: user.ID, Name: "cli", Scope: "read", Hash: "one"})
+	require.NoError(t, err)
+	require.NotZero(t, token.ID)
+	require.NotZero(t, token.Created)
+	require.Nil(t, token.LastUsed)
+	_, err = st.CreateAPIToken(ctx, server.APIToken{UserID: user.ID, Name: "ci", Scope: "write", Hash: "two"})
+	require.NoError(t, err)
+	used, err := st.UseAPIToken(ctx, "one")
+	require.NoError(t, err)
+	require.Equal(t, token.ID, used.ID)
+	require.NotNil(t, used.LastUsed)
+	_, err = st.UseAPIToken(ctx, "three")
+	require.Equal(t, server.ErrNotFound, err)
+
+	// only the token's user can revoke it, and revoked tokens can't be used
+	_, err = st.RevokeAPIToken(ctx, user.ID+1, token.ID)
+	require.Equal(t, server.ErrNotFound, err)
+	revoked, err := st.RevokeAPIToken(ctx, user.ID, token.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.Revoked)
+	_, err = st.UseAPIToken(ctx, "one")
+	require.Equal(t, server.ErrNotFound, err)
+	tokens, err := st.FindAPITokensByUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	require.Equal(t, token.ID, tokens[0].ID)
+	require.NotNil(t, tokens[0].Revoked)
+	require.Equal(t, "ci", tokens[1].Name)
+
+	_, err = st.FindUserByIdentity(ctx, "google", "123")
+	require.Equal(t, server.ErrNotFound, err)
+	require.NoError(t, st.LinkIdentity(ctx, user.ID, login.Identity{Provider: "google", Subject: "123", Email: "keys@example.com"}))
+	require.Error(t, st.LinkIdentity(ctx, user.ID, login.Identity{Provider: "google", Subject: "123"}))
+	found, err := st.FindUserByIdentity(ctx, "google", "123")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, found.ID)
+	identities, err := st.FindIdentitiesByUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	require.Equal(t, "123", identities[0].Subject)
+	require.Equal(t, "keys@example.com", *identities[0].Email)
+
+	cred, err := st.CreateWebAuthnCredential(ctx, server.WebAuthnCredential{ID: []byte{1, 2, 3}, UserID: user.ID, Name: "laptop", PublicKey: []byte{4, 5, 6}, SignCount: 1})
+	require.NoError(t, err)
+	require.NotZero(t, cred.Created)
+	_, err = st.FindWebAuthnCredential(ctx, []byte{3, 2, 1})
+	require.Equal(t, server.ErrNotFound, err)
+	// sign counts have to increase unless the authenticator doesn't count
+	challenged := time.Now()
+	require.Equal(t, webauthn.ErrSignCount, st.UpdateWebAuthnCredentialSignCount(ctx, cred.ID, 1, challenged))
+	require.NoError(t, st.UpdateWebAuthnCredentialSignCount(ctx, cred.ID, 2, challenged))
+	// and challenges made before the passkey's last use are replays
+	require.Equal(t, webauthn.ErrSignCount, st.UpdateWebAuthnCredentialSignCount(ctx, cred.ID, 0, challenged))
+	require.NoError(t, st.UpdateWebAuthnCredentialSignCount(ctx, cred.ID, 0, time.Now()))
+	updated, err := st.FindWebAuthnCredential(ctx, cred.ID)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 5, 6}, updated.PublicKey)
+	require.Equal(t, uint32(0), updated.SignCount)
+	require.NotNil(t, updated.LastUsed)
+	creds, err := st.FindWebAuthnCredentialsByUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, creds, 1)
+	require.Equal(t, "laptop", creds[0].Name)
+
+	// deleting the user deletes their credentials
+	require.NoError(t, st.DeleteUser(ctx, user))
+	_, err = st.UseAPIToken(ctx, "two")
+	require.Equal(t, server.ErrNotFound, err)
+	_, err = st.FindUserByIdentity(ctx, "google", "123")
+	require.Equal(t, server.ErrNotFound, err)
+	_, err = st.FindWebAuthnCredential(ctx, cred.ID)
+	require.Equal(t, server.ErrNotFound, err)
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// graphql-ws message types, see
+// https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md.
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionError     = "connection_error"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlStop                = "stop"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+)
+
+// subscriptionRootKey is the root object's key for the subscription being executed.
+const subscriptionRootKey = "subscription"
+
+var (
+	errNeedsWebSocket     = errors.New("subscriptions need a graphql-ws websocket")
+	errSubscriptionFields = errors.New("subscriptions must select a single field")
+	errNotInitialized     = errors.New("connection_init must be sent first")
+)
+
+var graphqlUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{"graphql-ws"},
+}
+
+// documentEvents notifies subscribers when documents are saved. Events are only sent to
+// subscribers connected to the same server.
+type documentEvents struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan Document]struct{}
+}
+
+func newDocumentEvents() *documentEvents {
+	return &documentEvents{subscribers: make(map[int]map[chan Document]struct{})}
+}
+
+// subscribe returns a channel that gets the document each time it's saved. Subscribers that fall
+// behind only get the latest save.
+func (e *documentEvents) subscribe(id int) chan Document {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch := make(chan Document, 1)
+	if e.subscribers[id] == nil {
+		e.subscribers[id] = make(map[chan Document]struct{})
+	}
+	e.subscribers[id][ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops sending the document's saves to the channel.
+func (e *documentEvents) unsubscribe(id int, ch chan Document) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subscribers[id], ch)
+	if len(e.subscribers[id]) == 0 {
+		delete(e.subscribers, id)
+	}
+}
+
+// publish sends the saved document to its subscribers.
+func (e *documentEvents) publish(d Document) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers[d.ID] {
+		select {
+		case ch <- d:
+		default:
+			// replace the save they haven't got to yet, publishes are serialized by the lock so
+			// there's room after.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- d
+		}
+	}
+}
+
+// subscriptionRoot is the root value subscriptions are executed with. They're executed once when
+// they start, with no document, to check their arguments and find what to subscribe to, then again
+// with each saved document to resolve the data sent to the client.
+type subscriptionRoot struct {
+	document    *Document
+	documentIDs []int
+}
+
+// subscriptionFromParams returns the subscription being executed, or errNeedsWebSocket if the
+// operation wasn't sent over one.
+func subscriptionFromParams(p graphql.ResolveParams) (*subscriptionRoot, error) {
+	source, _ := p.Source.(map[string]interface{})
+	root, ok := source[subscriptionRootKey].(*subscriptionRoot)
+	if !ok {
+		return nil, errNeedsWebSocket
+	}
+	return root, nil
+}
+
+// gqlMessage is a graphql-ws protocol message.
+type gqlMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// gqlStartPayload is the operation a start message runs.
+type gqlStartPayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// gqlConn is a graphql-ws connection and the subscriptions running on it.
+type gqlConn struct {
+	s      *Server
+	conn   *websocket.Conn
+	ctx    context.Context
+	viewer *Viewer
+
+	// writeMu serializes writes to the connection.
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	subscriptions map[string]context.CancelFunc
+}
+
+// HandleGraphqlWS runs queries, mutations and subscriptions sent over a WebSocket with the
+// graphql-ws protocol.
+func (s *Server) HandleGraphqlWS(w http.ResponseWriter, r *http.Request) {
+	viewer, err := s.authenticate(r)
+	if err != nil {
+		log.Printf("[error] failed to authenticate: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// the upgrader responds with the error itself.
+	conn, err := graphqlUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if viewer != nil {
+		ctx = withViewer(ctx, viewer)
+	}
+	c := &gqlConn{
+		s:             s,
+		conn:          conn,
+		ctx:           ctx,
+		viewer:        viewer,
+		subscriptions: make(map[string]context.CancelFunc),
+	}
+	go c.ping(ctx)
+	c.readLoop()
+	conn.Close()
+}
+
+// readLoop handles the client's messages until it disconnects or terminates the connection.
+func (c *gqlConn) readLoop() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	initialized := false
+	for {
+		var m gqlMessage
+		if err := c.conn.ReadJSON(&m); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				log.Printf("[error] failed to read message: %v", err)
+			}
+			return
+		}
+		switch m.Type {
+		case gqlConnectionInit:
+			initialized = true
+			c.write(gqlMessage{Type: gqlConnectionAck})
+		case gqlStart:
+			if !initialized {
+				c.write(gqlMessage{Type: gqlConnectionError, Payload: errorPayload(errNotInitialized)})
+				return
+			}
+			var payload gqlStartPayload
+			if err := json.Unmarshal(m.Payload, &payload); err != nil {
+				c.write(gqlMessage{ID: m.ID, Type: gqlError, Payload: errorPayload(errInvalidMessage)})
+				continue
+			}
+			c.start(m.ID, payload)
+		case gqlStop:
+			c.stop(m.ID)
+		case gqlConnectionTerminate:
+			return
+		default:
+			c.write(gqlMessage{ID: m.ID, Type: gqlError, Payload: errorPayload(errInvalidMessage)})
+		}
+	}
+}
+
+// start runs the operation. Queries and mutations send their result and complete, subscriptions
+// send theirs each time one of their events happens until they're stopped.
+func (c *gqlConn) start(id string, payload gqlStartPayload) {
+	if c.viewer != nil && c.viewer.Scope == scopeRead && hasMutation(payload.Query) {
+		c.write(gqlMessage{ID: id, Type: gqlError, Payload: errorPayload(errReadOnly)})
+		return
+	}
+	params := graphql.Params{
+		Schema:         c.s.schema,
+		RequestString:  payload.Query,
+		VariableValues: payload.Variables,
+		OperationName:  payload.OperationName,
+		Context:        c.ctx,
+	}
+	if !hasOperation(payload.Query, ast.OperationTypeSubscription) {
+		c.writeResult(id, graphql.Do(params))
+		c.write(gqlMessage{ID: id, Type: gqlComplete})
+		return
+	}
+
+	root := &subscriptionRoot{}
+	params.RootObject = map[string]interface{}{subscriptionRootKey: root}
+	result := graphql.Do(params)
+	if len(result.Errors) == 0 && len(root.documentIDs) != 1 {
+		result.Errors = []gqlerrors.FormattedError{gqlerrors.FormatError(errSubscriptionFields)}
+	}
+	if len(result.Errors) > 0 {
+		payload, _ := json.Marshal(result.Errors)
+		c.write(gqlMessage{ID: id, Type: gqlError, Payload: payload})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	if stop, ok := c.subscriptions[id]; ok {
+		stop()
+	}
+	c.subscriptions[id] = cancel
+	c.mu.Unlock()
+
+	documentID := root.documentIDs[0]
+	events := c.s.events.subscribe(documentID)
+	log.Printf("[debug] subscribed to document: %d", documentID)
+	go func() {
+		defer c.s.events.unsubscribe(documentID, events)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case d := <-events:
+				params.RootObject = map[string]interface{}{subscriptionRootKey: &subscriptionRoot{document: &d}}
+				c.writeResult(id, graphql.Do(params))
+			}
+		}
+	}()
+}
+
+// stop ends the subscription.
+func (c *gqlConn) stop(id string) {
+	c.mu.Lock()
+	if stop, ok := c.subscriptions[id]; ok {
+		stop()
+		delete(c.subscriptions, id)
+	}
+	c.mu.Unlock()
+	c.write(gqlMessage{ID: id, Type: gqlComplete})
+}
+
+// writeResult sends the operation's result.
+func (c *gqlConn) writeResult(id string, result *graphql.Result) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("[error] failed to encode json: %v", err)
+		return
+	}
+	c.write(gqlMessage{ID: id, Type: gqlData, Payload: payload})
+}
+
+// write sends the message, it's safe to call from the subscriptions' goroutines.
+func (c *gqlConn) write(m gqlMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := c.conn.WriteJSON(m); err != nil {
+		log.Printf("[debug] failed to write message: %v", err)
+	}
+}
+
+// ping pings the client until the context is done.
+func (c *gqlConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			c.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// errorPayload returns the error formatted as a graphql-ws error payload.
+func errorPayload(err error) json.RawMessage {
+	payload, _ := json.Marshal(gqlerrors.FormatError(err))
+	return payload
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DocumentState is whether a document is in use, archived or in the trash.
+type DocumentState string
+
+const (
+	// DocumentActive documents are listed by default.
+	DocumentActive DocumentState = "active"
+	// DocumentArchived documents are kept but hidden from default listings.
+	DocumentArchived DocumentState = "archived"
+	// DocumentTrashed documents can be restored until they're purged after the trash retention.
+	DocumentTrashed DocumentState = "trashed"
+)
+
+// purgeInterval is how often expired trash is purged.
+const purgeInterval = time.Hour
+
+// purgeAt returns when the trashed document will be purged, or nil if it isn't in the trash.
+func (s *Server) purgeAt(d Document) *time.Time {
+	if d.State != DocumentTrashed || d.Trashed == nil {
+		return nil
+	}
+	t := d.Trashed.Add(s.Config.TrashRetention)
+	return &t
+}
+
+// PurgeTrash deletes the documents that have been in the trash longer than the trash retention.
+func (s *Server) PurgeTrash(ctx context.Context) (int, error) {
+	return s.Documents.PurgeDocuments(ctx, time.Now().Add(-s.Config.TrashRetention))
+}
+
+// runTrashPurge purges expired trash every purgeInterval until the context is done.
+func (s *Server) runTrashPurge(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := s.PurgeTrash(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[error] failed to purge trash: %v", err)
+		} else if n > 0 {
+			log.Printf("[info] purged %d trashed documents", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+var (
+	errUnauthenticated = errors.New("not signed in")
+	errReadOnly        = errors.New("read only token can't run mutations")
+	// errMutationNeedsJSON is returned for mutations that aren't POSTed as JSON.
+	errMutationNeedsJSON = errors.New("mutations must be POSTed as application/json")
+)
+
+// Viewer is the user making a request and what they're allowed to do.
+type Viewer struct {
+	User  User
+	Scope string
+}
+
+type viewerKey struct{}
+
+// withViewer returns a copy of ctx carrying the viewer.
+func withViewer(ctx context.Context, v *Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, v)
+}
+
+// viewerFromContext returns the viewer set on the context or errUnauthenticated.
+func viewerFromContext(ctx context.Context) (*Viewer, error) {
+	v, ok := ctx.Value(viewerKey{}).(*Viewer)
+	if !ok || v == nil {
+		return nil, errUnauthenticated
+	}
+	return v, nil
+}
+
+// authenticate returns the viewer for the request from its bearer token or its session. The viewer
+// is nil for anonymous requests and an error means the request had a bad token.
+func (s *Server) authenticate(r *http.Request) (*Viewer, error) {
+	auth := r.Header.Get("Authorization")
+	if auth != "" {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil, errInvalidAPIToken
+		}
+		token, err := s.AuthenticateAPIToken(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		user, err := s.Users.FindUserByID(r.Context(), token.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &Viewer{User: user, Scope: token.Scope}, nil
+	}
+	if user := s.sessionUser(r); user != nil {
+		return &Viewer{User: *user, Scope: scopeWrite}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxDepth bounds how deeply nested CBOR items may be.
+const maxDepth = 16
+
+var errTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR item in data and returns it and the remaining bytes. It
+// supports what authenticators send: unsigned and negative ints as int64, byte strings as []byte,
+// text strings as string, arrays as []interface{}, maps as map[interface{}]interface{}, and bools,
+// null and floats. Tags are ignored.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, errors.New("cbor: too deeply nested")
+	}
+	if len(data) == 0 {
+		return nil, nil, errTruncated
+	}
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		return decodeSimple(info, data)
+	}
+
+	arg, data, err := decodeArg(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: int overflows int64")
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: int overflows int64")
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if uint64(len(data)) < arg {
+			return nil, nil, errTruncated
+		}
+		b := make([]byte, arg)
+		copy(b, data[:arg])
+		if major == 3 {
+			return string(b), data[arg:], nil
+		}
+		return b, data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			item, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var k, v interface{}
+			k, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type: %T", k)
+			}
+			v, data, err = decodeItem(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, data, nil
+	case 6:
+		return decodeItem(data, depth+1)
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported major type: %d", major)
+}
+
+// decodeArg decodes the argument following the initial byte. Indefinite lengths aren't supported
+// since authenticators must use the canonical encoding.
+func decodeArg(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, fmt.Errorf("cbor: unsupported additional info: %d", info)
+}
+
+func decodeSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25:
+		if len(data) < 2 {
+			return nil, nil, errTruncated
+		}
+		// half precision floats aren't used by anything we read, skip the value.
+		return nil, data[2:], nil
+	case 26:
+		if len(data) < 4 {
+			return nil, nil, errTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case 27:
+		if len(data) < 8 {
+			return nil, nil, errTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported simple value: %d", info)
+}
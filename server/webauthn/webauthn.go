@@ -0,0 +1,343 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithms we support, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the COSE algorithms we accept credentials for.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// authenticator data flags.
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+)
+
+var (
+	// ErrSignCount means the authenticator's sign count didn't increase, so it may have been cloned.
+	ErrSignCount = errors.New("webauthn: sign count didn't increase")
+	errChallenge = errors.New("webauthn: challenge mismatch")
+)
+
+// RelyingParty verifies WebAuthn registrations and assertions for our site.
+type RelyingParty struct {
+	// ID is the site's domain, e.g. writegood.app.
+	ID string
+	// Origin is the site's origin, e.g. https://writegood.app.
+	Origin string
+	// RequireUserVerification requires the authenticator to have verified the user, e.g. with a
+	// PIN or biometrics, instead of only their presence.
+	RequireUserVerification bool
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded public key.
+	PublicKey []byte
+	SignCount uint32
+}
+
+// AttestationResponse is the credential returned by navigator.credentials.create() with its binary
+// fields base64url encoded.
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get() with its binary
+// fields base64url encoded.
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewChallenge returns a random challenge for a registration or assertion.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// Encode base64url encodes b the way the browser APIs expect.
+func Encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode decodes base64url with or without padding.
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// CredentialID returns the decoded ID of the credential in the response.
+func (res AssertionResponse) CredentialID() ([]byte, error) {
+	if res.RawID != "" {
+		return Decode(res.RawID)
+	}
+	return Decode(res.ID)
+}
+
+// VerifyRegistration verifies the response to the challenge and returns the new credential. We
+// request no attestation so the attestation statement isn't verified, the credential is trusted
+// because the user was signed in when they registered it.
+func (rp *RelyingParty) VerifyRegistration(res AttestationResponse, challenge []byte) (Credential, error) {
+	var cred Credential
+	if res.Type != "public-key" {
+		return cred, fmt.Errorf("webauthn: unexpected credential type: %s", res.Type)
+	}
+	clientData, err := Decode(res.Response.ClientDataJSON)
+	if err != nil {
+		return cred, err
+	}
+	if err = rp.verifyClientData(clientData, "webauthn.create", challenge); err != nil {
+		return cred, err
+	}
+	attestation, err := Decode(res.Response.AttestationObject)
+	if err != nil {
+		return cred, err
+	}
+	obj, _, err := decodeCBOR(attestation)
+	if err != nil {
+		return cred, err
+	}
+	m, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return cred, errors.New("webauthn: attestation object isn't a map")
+	}
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return cred, errors.New("webauthn: attestation object is missing authData")
+	}
+	ad, err := rp.parseAuthData(authData)
+	if err != nil {
+		return cred, err
+	}
+	if ad.flags&flagAttestedCredData == 0 {
+		return cred, errors.New("webauthn: authenticator data is missing the credential")
+	}
+	rest := ad.rest
+	if len(rest) < 18 {
+		return cred, errTruncated
+	}
+	// skip the aaguid.
+	rest = rest[16:]
+	idLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < idLen {
+		return cred, errTruncated
+	}
+	cred.ID = append([]byte(nil), rest[:idLen]...)
+	rest = rest[idLen:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return cred, err
+	}
+	cred.PublicKey = append([]byte(nil), rest[:len(rest)-len(after)]...)
+	if _, _, err = parsePublicKey(cred.PublicKey); err != nil {
+		return cred, err
+	}
+	if rawID, err := Decode(res.RawID); err == nil && len(rawID) > 0 && !bytes.Equal(rawID, cred.ID) {
+		return cred, errors.New("webauthn: credential id mismatch")
+	}
+	cred.SignCount = ad.signCount
+	return cred, nil
+}
+
+// VerifyAssertion verifies the response to the challenge was signed by the credential and returns
+// the authenticator's new sign count. ErrSignCount is returned if the sign count didn't increase.
+func (rp *RelyingParty) VerifyAssertion(res AssertionResponse, challenge []byte, cred Credential) (uint32, error) {
+	if res.Type != "public-key" {
+		return 0, fmt.Errorf("webauthn: unexpected credential type: %s", res.Type)
+	}
+	clientData, err := Decode(res.Response.ClientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+	if err = rp.verifyClientData(clientData, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := Decode(res.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	ad, err := rp.parseAuthData(authData)
+	if err != nil {
+		return 0, err
+	}
+	sig, err := Decode(res.Response.Signature)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err = verifySignature(cred.PublicKey, signed, sig); err != nil {
+		return 0, err
+	}
+	// authenticators that don't count always send zero.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return ad.signCount, ErrSignCount
+	}
+	return ad.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(data []byte, typ string, challenge []byte) error {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(data, &clientData); err != nil {
+		return err
+	}
+	if clientData.Type != typ {
+		return fmt.Errorf("webauthn: unexpected client data type: %s", clientData.Type)
+	}
+	got, err := Decode(clientData.Challenge)
+	if err != nil {
+		return err
+	}
+	if len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return errChallenge
+	}
+	if clientData.Origin != rp.Origin {
+		return fmt.Errorf("webauthn: unexpected origin: %s", clientData.Origin)
+	}
+	return nil
+}
+
+type authData struct {
+	flags     byte
+	signCount uint32
+	rest      []byte
+}
+
+func (rp *RelyingParty) parseAuthData(data []byte) (authData, error) {
+	var ad authData
+	if len(data) < 37 {
+		return ad, errTruncated
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data[:32], rpIDHash[:]) != 1 {
+		return ad, errors.New("webauthn: relying party id mismatch")
+	}
+	ad.flags = data[32]
+	if ad.flags&flagUserPresent == 0 {
+		return ad, errors.New("webauthn: user wasn't present")
+	}
+	if rp.RequireUserVerification && ad.flags&flagUserVerified == 0 {
+		return ad, errors.New("webauthn: user wasn't verified")
+	}
+	ad.signCount = binary.BigEndian.Uint32(data[33:37])
+	ad.rest = data[37:]
+	return ad, nil
+}
+
+// COSE key parameters.
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+)
+
+// parsePublicKey parses the COSE encoded key into its algorithm and public key.
+func parsePublicKey(data []byte) (int, crypto.PublicKey, error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, errors.New("webauthn: public key isn't a map")
+	}
+	alg, _ := m[int64(coseAlg)].(int64)
+	kty, _ := m[int64(coseKty)].(int64)
+	switch alg {
+	case AlgES256:
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		crv, _ := m[int64(coseCrv)].(int64)
+		if kty != 2 || crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("webauthn: invalid ES256 public key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return 0, nil, errors.New("webauthn: ES256 public key isn't on the curve")
+		}
+		return AlgES256, key, nil
+	case AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if kty != 3 || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, errors.New("webauthn: invalid RS256 public key")
+		}
+		return AlgRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case AlgEdDSA:
+		x, _ := m[int64(coseX)].([]byte)
+		crv, _ := m[int64(coseCrv)].(int64)
+		if kty != 1 || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("webauthn: invalid EdDSA public key")
+		}
+		return AlgEdDSA, ed25519.PublicKey(x), nil
+	}
+	return 0, nil, fmt.Errorf("webauthn: unsupported algorithm: %d", alg)
+}
+
+func verifySignature(publicKey, signed, sig []byte) error {
+	alg, key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(signed)
+	ok := false
+	switch alg {
+	case AlgES256:
+		ok = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), hash[:], sig)
+	case AlgRS256:
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, hash[:], sig) == nil
+	case AlgEdDSA:
+		ok = ed25519.Verify(key.(ed25519.PublicKey), signed, sig)
+	}
+	if !ok {
+		return errors.New("webauthn: invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/writegood/server/webauthn"
+)
+
+// pair is a CBOR map entry, maps are lists of pairs so the encoding's deterministic.
+type pair struct {
+	k, v interface{}
+}
+
+// encode is a minimal CBOR encoder for building what an authenticator would send.
+func encode(v interface{}) []byte {
+	var buf bytes.Buffer
+	head := func(major byte, n uint64) {
+		switch {
+		case n < 24:
+			buf.WriteByte(major<<5 | byte(n))
+		case n < 1<<8:
+			buf.WriteByte(major<<5 | 24)
+			buf.WriteByte(byte(n))
+		case n < 1<<16:
+			buf.WriteByte(major<<5 | 25)
+			_ = binary.Write(&buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(major<<5 | 26)
+			_ = binary.Write(&buf, binary.BigEndian, uint32(n))
+		}
+	}
+	switch t := v.(type) {
+	case int:
+		if t >= 0 {
+			head(0, uint64(t))
+		} else {
+			head(1, uint64(-1-t))
+		}
+	case []byte:
+		head(2, uint64(len(t)))
+		buf.Write(t)
+	case string:
+		head(3, uint64(len(t)))
+		buf.WriteString(t)
+	case []pair:
+		head(5, uint64(len(t)))
+		for _, p := range t {
+			buf.Write(encode(p.k))
+			buf.Write(encode(p.v))
+		}
+	default:
+		panic("unsupported type")
+	}
+	return buf.Bytes()
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func TestWebAuthn(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Origin: "http://localhost:8080"}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	coseKey := encode([]pair{
+		{1, 2},
+		{3, webauthn.AlgES256},
+		{-1, 1},
+		{-2, pad32(key.X.Bytes())},
+		{-3, pad32(key.Y.Bytes())},
+	})
+	credID := []byte("credential-id")
+
+	clientData := func(typ string, challenge []byte, origin string) string {
+		b, err := json.Marshal(map[string]string{
+			"type":      typ,
+			"challenge": webauthn.Encode(challenge),
+			"origin":    origin,
+		})
+		require.NoError(t, err)
+		return webauthn.Encode(b)
+	}
+
+	authData := func(flags byte, signCount uint32, rest []byte) []byte {
+		var buf bytes.Buffer
+		buf.Write(rpIDHash[:])
+		buf.WriteByte(flags)
+		_ = binary.Write(&buf, binary.BigEndian, signCount)
+		buf.Write(rest)
+		return buf.Bytes()
+	}
+
+	// register
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16))
+	_ = binary.Write(&attested, binary.BigEndian, uint16(len(credID)))
+	attested.Write(credID)
+	attested.Write(coseKey)
+
+	var attestation webauthn.AttestationResponse
+	attestation.Type = "public-key"
+	attestation.RawID = webauthn.Encode(credID)
+	attestation.Response.ClientDataJSON = clientData("webauthn.create", challenge, rp.Origin)
+	attestation.Response.AttestationObject = webauthn.Encode(encode([]pair{
+		{"fmt", "none"},
+		{"attStmt", []pair{}},
+		{"authData", authData(0x41, 0, attested.Bytes())},
+	}))
+
+	cred, err := rp.VerifyRegistration(attestation, challenge)
+	require.NoError(t, err)
+	require.Equal(t, credID, cred.ID)
+	require.Equal(t, coseKey, cred.PublicKey)
+	require.Equal(t, uint32(0), cred.SignCount)
+
+	_, err = rp.VerifyRegistration(attestation, []byte("other challenge"))
+	require.Error(t, err)
+
+	// sign in
+	assert := func(challenge []byte, origin string, signCount uint32) webauthn.AssertionResponse {
+		ad := authData(0x01, signCount, nil)
+		cd := clientData("webauthn.get", challenge, origin)
+		rawCD, err := webauthn.Decode(cd)
+		require.NoError(t, err)
+		cdHash := sha256.Sum256(rawCD)
+		hash := sha256.Sum256(append(append([]byte(nil), ad...), cdHash[:]...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		require.NoError(t, err)
+
+		var res webauthn.AssertionResponse
+		res.Type = "public-key"
+		res.RawID = webauthn.Encode(credID)
+		res.Response.ClientDataJSON = cd
+		res.Response.AuthenticatorData = webauthn.Encode(ad)
+		res.Response.Signature = webauthn.Encode(sig)
+		return res
+	}
+
+	challenge, err = webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	signCount, err := rp.VerifyAssertion(assert(challenge, rp.Origin, 1), challenge, cred)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), signCount)
+	cred.SignCount = signCount
+
+	id, err := assert(challenge, rp.Origin, 2).CredentialID()
+	require.NoError(t, err)
+	require.Equal(t, credID, id)
+
+	// replayed or cloned authenticators don't increase the sign count
+	_, err = rp.VerifyAssertion(assert(challenge, rp.Origin, 1), challenge, cred)
+	require.Equal(t, webauthn.ErrSignCount, err)
+
+	_, err = rp.VerifyAssertion(assert(challenge, "https://evil.example.com", 2), challenge, cred)
+	require.Error(t, err)
+
+	_, err = rp.VerifyAssertion(assert([]byte("other challenge"), rp.Origin, 2), challenge, cred)
+	require.Error(t, err)
+
+	// signed by a different key
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key = other
+	_, err = rp.VerifyAssertion(assert(challenge, rp.Origin, 2), challenge, cred)
+	require.Error(t, err)
+}